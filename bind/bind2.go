@@ -0,0 +1,82 @@
+package bind
+
+import (
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+// Bind2 handles resource binding inline with SASL2 authentication as
+// described in XEP-0386. It implements the sasl.InlineFeature interface and
+// uses the resource logic of a Handler.
+//
+// Other features, such as stream management or message carbons, can be
+// enabled in the same round trip by registering handlers for their
+// namespaces.
+type Bind2 struct {
+	h        Handler
+	features []bind2Entry
+}
+
+type bind2Entry struct {
+	space string
+	h     stream.ElementHandler
+}
+
+// NewBind2 creates a new Bind2 that binds resources using h.
+func NewBind2(h Handler) Bind2 {
+	return Bind2{h: h}
+}
+
+// Handle registers the ElementHandler for children of the bind request in the
+// given namespace. The elements returned by the handler are added to the
+// bound element. The namespace is advertised as an inline feature of Bind 2.
+func (b Bind2) Handle(space string, h stream.ElementHandler) Bind2 {
+	b.features = append(b.features, bind2Entry{space: space, h: h})
+	return b
+}
+
+// Inline implements the sasl.InlineFeature interface.
+func (b Bind2) Inline(props stream.Properties) element.Element {
+	if props.Status&stream.Bind != 0 {
+		return element.Element{}
+	}
+	el := element.New("bind").AddAttr("xmlns", namespace.Bind2)
+	if len(b.features) == 0 {
+		return el
+	}
+	inline := element.New("inline")
+	for _, f := range b.features {
+		inline = inline.AddChild(element.New("feature").AddAttr("var", f.space))
+	}
+	return el.AddChild(inline)
+}
+
+// HandleInline implements the sasl.InlineFeature interface. The resource is
+// generated by the server using the tag sent by the client as a prefix.
+func (b Bind2) HandleInline(el element.Element, props stream.Properties) ([]element.Element, stream.Properties) {
+	resource := genResourceID()
+	if tag := el.SelectElement("tag").Text(); tag != "" {
+		resource = tag + "." + resource[:8]
+	}
+	_, props, err := b.h.bindResource(resource, props)
+	if err != nil {
+		// Generated resources should never conflict; bind nothing if one does.
+		return []element.Element{}, props
+	}
+
+	bound := element.New("bound").AddAttr("xmlns", namespace.Bind2)
+	for _, child := range el.ChildElements() {
+		for _, f := range b.features {
+			if child.MatchNamespace(f.space) {
+				var elems []element.Element
+				elems, props = f.h.HandleElement(child, props)
+				for _, elem := range elems {
+					bound = bound.AddChild(elem)
+				}
+				break
+			}
+		}
+	}
+	return []element.Element{bound}, props
+}
@@ -0,0 +1,87 @@
+package bind
+
+import (
+	"log"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/element/stanza"
+	"github.com/skriptble/nine/jid"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+// The IDs of the IQs sent by ClientHandler.
+const (
+	bindID    = "bind_1"
+	sessionID = "session_1"
+)
+
+// ClientHandler binds a resource for streams in initiating mode. It
+// implements stream.FeatureHandler for the bind feature and
+// stream.ElementHandler for the IQ results sent in response.
+//
+// If the receiving entity offers the legacy session feature of RFC 3921
+// without marking it optional, a session is established after the resource
+// is bound.
+type ClientHandler struct {
+	resource string
+}
+
+// NewClientHandler creates a new ClientHandler that requests the resource.
+// If resource is empty, the receiving entity generates one.
+func NewClientHandler(resource string) ClientHandler {
+	return ClientHandler{resource: resource}
+}
+
+// HandleFeature implements the stream.FeatureHandler interface.
+func (ch ClientHandler) HandleFeature(_ element.Element, props stream.Properties) ([]element.Element, stream.Properties) {
+	if props.Status&stream.Auth == 0 || props.Status&stream.Bind != 0 {
+		return []element.Element{}, props
+	}
+	bind := element.Bind
+	if ch.resource != "" {
+		bind = bind.AddChild(element.New("resource").SetText(ch.resource))
+	}
+	st := stanza.NewStanza(jid.Empty, jid.Empty, bindID, string(stanza.IQSet)).AddChild(bind)
+	return []element.Element{stanza.IQ{}.LoadStanza(st).TransformElement()}, props
+}
+
+// HandleElement implements the stream.ElementHandler interface. It handles
+// the responses to the bind and session IQs.
+func (ch ClientHandler) HandleElement(el element.Element, props stream.Properties) ([]element.Element, stream.Properties) {
+	iq := stanza.TransformIQ(el)
+	switch iq.ID {
+	case bindID:
+		res, err := stanza.TransformBindResult(iq)
+		if err != nil {
+			log.Printf("Could not bind resource: %s", iq)
+			props.Status = props.Status | stream.Closed
+			return []element.Element{}, props
+		}
+		props.JID = res.JID()
+		props.Status = props.Status | stream.Bind
+		if !sessionRequired(props.Features) {
+			return []element.Element{}, props
+		}
+		st := stanza.NewStanza(jid.New(props.Domain), jid.Empty, sessionID, string(stanza.IQSet)).
+			AddChild(element.Session)
+		return []element.Element{stanza.IQ{}.LoadStanza(st).TransformElement()}, props
+	case sessionID:
+		if iq.Type != string(stanza.IQResult) {
+			log.Printf("Could not establish session: %s", iq)
+			props.Status = props.Status | stream.Closed
+		}
+	}
+	return []element.Element{}, props
+}
+
+// sessionRequired reports whether the features contain a session feature that
+// is not marked as optional.
+func sessionRequired(features []element.Element) bool {
+	for _, f := range features {
+		if f.Tag == "session" && f.MatchNamespace(namespace.Session) {
+			return f.SelectElement("optional").Tag == ""
+		}
+	}
+	return false
+}
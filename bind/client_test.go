@@ -0,0 +1,55 @@
+package bind
+
+import (
+	"testing"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/jid"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+func TestClientHandler(t *testing.T) {
+	t.Parallel()
+
+	ch := NewClientHandler("balcony")
+	props := stream.Properties{Domain: "localhost", Status: stream.Auth}
+	props.Features = []element.Element{element.Bind, element.Session}
+
+	// Should request the preferred resource.
+	elems, props := ch.HandleFeature(element.Bind, props)
+	if len(elems) != 1 || elems[0].SelectElement("bind").SelectElement("resource").Text() != "balcony" {
+		t.Error("Should request the preferred resource.")
+		t.Errorf("\nGot :%v", elems)
+	}
+
+	// Should store the bound JID and establish a required session.
+	result := element.New("iq").AddAttr("type", "result").AddAttr("id", elems[0].SelectAttrValue("id", "")).
+		AddChild(element.New("bind").AddAttr("xmlns", namespace.Bind).
+			AddChild(element.New("jid").SetText("juliet@localhost/balcony")))
+	elems, props = ch.HandleElement(result, props)
+	if props.JID != jid.New("juliet@localhost/balcony") || props.Status&stream.Bind == 0 {
+		t.Error("Should store the bound JID.")
+		t.Errorf("\nWant:%s\nGot :%s", "juliet@localhost/balcony", props.JID)
+	}
+	if len(elems) != 1 || elems[0].SelectElement("session").Tag == "" {
+		t.Error("Should establish a required session.")
+		t.Errorf("\nGot :%v", elems)
+	}
+
+	// Should not establish an optional session.
+	props = stream.Properties{Domain: "localhost", Status: stream.Auth}
+	props.Features = []element.Element{element.Bind, element.Session.AddChild(element.New("optional"))}
+	elems, _ = ch.HandleElement(result, props)
+	if len(elems) != 0 {
+		t.Error("Should not establish an optional session.")
+		t.Errorf("\nGot :%v", elems)
+	}
+
+	// Should close the stream when binding fails.
+	failed := element.New("iq").AddAttr("type", "error").AddAttr("id", result.SelectAttrValue("id", ""))
+	_, props = ch.HandleElement(failed, props)
+	if props.Status&stream.Closed == 0 {
+		t.Error("Should close the stream when binding fails.")
+	}
+}
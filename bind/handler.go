@@ -2,23 +2,43 @@ package bind
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	mrand "math/rand"
 	"strconv"
 
+	"golang.org/x/text/secure/precis"
+
 	"github.com/skriptble/nine/element"
 	"github.com/skriptble/nine/element/stanza"
 	"github.com/skriptble/nine/jid"
 	"github.com/skriptble/nine/stream"
 )
 
+// ErrInvalidResource is returned when a requested resource is not allowed by
+// the OpaqueString profile of RFC 7613 or is too long.
+var ErrInvalidResource = errors.New("bind: invalid resource")
+
+// ErrNotAllowed is returned when the stream is not allowed to bind a
+// resource, because it is not authenticated or has already bound one.
+var ErrNotAllowed = errors.New("bind: not allowed")
+
 type Handler struct {
+	registry *Registry
 }
 
 func NewHandler() Handler {
 	return Handler{}
 }
 
+// SetRegistry sets the Registry consulted when a resource is bound. Without a
+// registry, conflicting resources are not detected and the number of
+// resources per account is not limited.
+func (h Handler) SetRegistry(r *Registry) Handler {
+	h.registry = r
+	return h
+}
+
 func (h Handler) GenerateFeature(props stream.Properties) stream.Properties {
 	if props.Status&stream.Bind != 0 || props.Status&stream.Auth == 0 {
 		return props
@@ -30,27 +50,65 @@ func (h Handler) GenerateFeature(props stream.Properties) stream.Properties {
 
 func (h Handler) HandleIQ(iq stanza.IQ, props stream.Properties) ([]stanza.Stanza, stream.Properties) {
 	var sts []stanza.Stanza
-	// ensure we have a bind request
 	req, err := stanza.TransformBindRequest(iq)
 	if err != nil {
-		// TODO: Should this return an error?
+		sts = append(sts, stanza.NewIQError(iq, element.Stanza.BadRequest).TransformStanza())
 		return sts, props
 	}
-	if req.Resource == "" {
-		// TODO: Create a random resource generator
-		req.Resource = genResourceID()
+	var j jid.JID
+	j, props, err = h.bindResource(req.Resource, props)
+	if err != nil {
+		sts = append(sts, stanza.NewIQError(iq, bindError(err)).TransformStanza())
+		return sts, props
 	}
-
-	// Should do some resource validation here.
-	// TODO: Need to use proper jids here.
-	props.Header.To += "/" + req.Resource
-
-	j := jid.New(props.Header.To)
 	res := stanza.NewBindResult(iq, j)
 	sts = append(sts, res.TransformStanza())
+	return sts, props
+}
+
+// bindError returns the stanza error condition for an error returned while
+// binding a resource, as described in RFC 6120 section 7.6.2.
+func bindError(err error) element.Element {
+	switch err {
+	case ErrInvalidResource:
+		return element.Stanza.BadRequest
+	case ErrNotAllowed:
+		return element.Stanza.NotAllowed
+	case ErrResourceConstraint:
+		return element.Stanza.ResourceConstraint
+	case ErrConflict:
+		return element.Stanza.Conflict
+	}
+	return element.Stanza.InternalServerError
+}
+
+// bindResource binds the resource to the stream and sets the Bind status. If
+// resource is empty a random resource is generated. If the handler has a
+// registry, the full JID is bound there first and its policies applied.
+func (h Handler) bindResource(resource string, props stream.Properties) (jid.JID, stream.Properties, error) {
+	if props.Status&stream.Auth == 0 || props.Status&stream.Bind != 0 {
+		return jid.Empty, props, ErrNotAllowed
+	}
+	if resource == "" {
+		resource = genResourceID()
+	}
+	resource, err := precis.OpaqueString.String(resource)
+	if err != nil || len(resource) > 1023 {
+		return jid.Empty, props, ErrInvalidResource
+	}
+
+	j := jid.New(props.Header.To).WithResource(resource)
+	if h.registry != nil {
+		j, err = h.registry.Bind(j, props)
+		if err != nil {
+			return jid.Empty, props, err
+		}
+	}
+	props.JID = j
+	props.Header.To = j.String()
 
 	props.Status = props.Status | stream.Bind
-	return sts, props
+	return j, props, nil
 }
 
 func genResourceID() string {
@@ -0,0 +1,154 @@
+package bind
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/jid"
+	"github.com/skriptble/nine/stream"
+)
+
+// ErrConflict is returned by Registry.Bind when the full JID is already bound
+// to another stream and the conflict policy is Reject.
+var ErrConflict = errors.New("bind: resource conflict")
+
+// ErrResourceConstraint is returned by Registry.Bind when the account has
+// reached the maximum number of bound resources.
+var ErrResourceConstraint = errors.New("bind: too many resources")
+
+// conflictTimeout is how long writing the conflict stream error to a replaced
+// session can take before its conn is closed.
+const conflictTimeout = 5 * time.Second
+
+// ConflictPolicy determines what happens when a stream binds a full JID that
+// is already bound to another stream. The policies are described in RFC 6120
+// section 7.7.2.2.
+type ConflictPolicy int
+
+// The conflict policies.
+const (
+	// Reject refuses the new binding with a conflict error.
+	Reject ConflictPolicy = iota
+	// Replace terminates the existing stream with a conflict stream error
+	// and binds the full JID to the new stream.
+	Replace
+	// Rename binds the new stream to a resource modified by the server.
+	Rename
+)
+
+// Registry is a server wide registry of bound sessions. It is safe for
+// concurrent use and should be shared by all streams of a server. The registry
+// implements stream.CloseHandler and must be added as a close handler to every
+// stream so entries are removed when a stream ends.
+type Registry struct {
+	policy       ConflictPolicy
+	maxResources int
+
+	mu       sync.Mutex
+	sessions map[string]session
+	// counts is the number of resources bound for each bare JID.
+	counts map[string]int
+}
+
+// session is a stream bound to a full JID. The id is the stream ID.
+type session struct {
+	id   string
+	conn stream.Conn
+}
+
+// NewRegistry creates a new, empty Registry that resolves conflicts with the
+// given policy.
+func NewRegistry(policy ConflictPolicy) *Registry {
+	return &Registry{
+		policy:   policy,
+		sessions: make(map[string]session),
+		counts:   make(map[string]int),
+	}
+}
+
+// SetMaxResources sets the maximum number of resources that can be bound for
+// an account at the same time. A value of zero means there is no limit.
+func (r *Registry) SetMaxResources(n int) *Registry {
+	r.maxResources = n
+	return r
+}
+
+// Bind binds the full JID to the stream with the properties. It returns the
+// JID that was bound, which differs from j when the policy is Rename and j is
+// already bound.
+func (r *Registry) Bind(j jid.JID, props stream.Properties) (jid.JID, error) {
+	j, old, err := r.bind(j, props)
+	if old != nil {
+		// The replaced session is closed without holding the lock. Writing to
+		// a peer which stopped reading blocks until the conn is closed, so it
+		// is closed after a timeout.
+		timer := time.AfterFunc(conflictTimeout, func() { old.Close() })
+		old.WriteElement(element.StreamError.Conflict)
+		if timer.Stop() {
+			old.Close()
+		}
+	}
+	return j, err
+}
+
+// bind binds the full JID and returns the conn of the session it replaced,
+// if any.
+func (r *Registry) bind(j jid.JID, props stream.Properties) (jid.JID, stream.Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bare := j.Bare().String()
+	old, ok := r.sessions[j.String()]
+	replace := ok && r.policy == Replace
+	var conflict stream.Conn
+	if ok && old.id != props.Header.ID {
+		switch r.policy {
+		case Replace:
+			conflict = old.conn
+		case Rename:
+			for ok {
+				j = j.WithResource(j.Resource() + "." + genResourceID()[:8])
+				_, ok = r.sessions[j.String()]
+			}
+		default:
+			return jid.Empty, nil, ErrConflict
+		}
+	}
+	if !replace && r.maxResources > 0 && r.counts[bare] >= r.maxResources {
+		return jid.Empty, nil, ErrResourceConstraint
+	}
+	if !replace {
+		r.counts[bare]++
+	}
+	r.sessions[j.String()] = session{id: props.Header.ID, conn: props.Conn}
+	return j, conflict, nil
+}
+
+// Bound reports whether the full JID is bound to a stream.
+func (r *Registry) Bound(j jid.JID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.sessions[j.String()]
+	return ok
+}
+
+// HandleClose implements the stream.CloseHandler interface. It removes the
+// full JID bound by the stream, unless it has since been bound by another
+// stream.
+func (r *Registry) HandleClose(props stream.Properties) {
+	if props.Status&stream.Bind == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := props.JID.String()
+	if s, ok := r.sessions[key]; ok && s.id == props.Header.ID {
+		delete(r.sessions, key)
+		bare := props.JID.Bare().String()
+		if r.counts[bare]--; r.counts[bare] <= 0 {
+			delete(r.counts, bare)
+		}
+	}
+}
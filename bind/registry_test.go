@@ -0,0 +1,179 @@
+package bind
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/element/stanza"
+	"github.com/skriptble/nine/jid"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+type fakeConn struct {
+	written []element.Element
+	closed  bool
+}
+
+func (fc *fakeConn) WriteElement(el element.Element) error {
+	fc.written = append(fc.written, el)
+	return nil
+}
+
+func (fc *fakeConn) Close() error {
+	fc.closed = true
+	return nil
+}
+
+// blockingConn is a stream.Conn for a peer which stopped reading. Writes
+// block until the conn is closed.
+type blockingConn struct {
+	writing chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (bc *blockingConn) WriteElement(el element.Element) error {
+	close(bc.writing)
+	<-bc.closed
+	return io.ErrClosedPipe
+}
+
+func (bc *blockingConn) Close() error {
+	bc.once.Do(func() { close(bc.closed) })
+	return nil
+}
+
+func bindIQ(resource string) stanza.IQ {
+	bind := element.Bind.AddChild(element.New("resource").SetText(resource))
+	bind.Space = namespace.Bind
+	st := stanza.NewStanza(jid.Empty, jid.New("juliet@localhost"), "bind_1", string(stanza.IQSet))
+	st = st.AddChild(bind)
+	return stanza.IQ{}.LoadStanza(st)
+}
+
+func authenticated(id string, conn stream.Conn) stream.Properties {
+	props := stream.Properties{Domain: "localhost", Status: stream.Auth, Conn: conn}
+	props.Header.ID = id
+	props.Header.To = "juliet@localhost"
+	return props
+}
+
+func TestRegistryPolicies(t *testing.T) {
+	t.Parallel()
+
+	// Should reject a conflicting resource.
+	h := NewHandler().SetRegistry(NewRegistry(Reject))
+	_, first := h.HandleIQ(bindIQ("balcony"), authenticated("1", &fakeConn{}))
+	sts, props := h.HandleIQ(bindIQ("balcony"), authenticated("2", &fakeConn{}))
+	if len(sts) != 1 || sts[0].Type != string(stanza.IQError) || props.Status&stream.Bind != 0 {
+		t.Error("Should reject a conflicting resource.")
+		t.Errorf("\nGot :%v", sts)
+	}
+
+	// Should remove the entry when the stream ends.
+	h.registry.HandleClose(first)
+	if h.registry.Bound(jid.New("juliet@localhost/balcony")) {
+		t.Error("Should remove the entry when the stream ends.")
+	}
+
+	// Should replace the old session with a conflict stream error.
+	h = NewHandler().SetRegistry(NewRegistry(Replace))
+	old := &fakeConn{}
+	_, first = h.HandleIQ(bindIQ("balcony"), authenticated("1", old))
+	_, props = h.HandleIQ(bindIQ("balcony"), authenticated("2", &fakeConn{}))
+	if !old.closed || len(old.written) != 1 || old.written[0].SelectElement("conflict").Tag == "" {
+		t.Error("Should replace the old session with a conflict stream error.")
+		t.Errorf("\nGot :%v", old.written)
+	}
+	if props.Header.To != "juliet@localhost/balcony" {
+		t.Errorf("\nWant:%s\nGot :%s", "juliet@localhost/balcony", props.Header.To)
+	}
+	// The old stream ending should not remove the new session.
+	h.registry.HandleClose(first)
+	if !h.registry.Bound(jid.New("juliet@localhost/balcony")) {
+		t.Error("The old stream ending should not remove the new session.")
+	}
+
+	// Should assign a server modified resource.
+	h = NewHandler().SetRegistry(NewRegistry(Rename))
+	h.HandleIQ(bindIQ("balcony"), authenticated("1", &fakeConn{}))
+	_, props = h.HandleIQ(bindIQ("balcony"), authenticated("2", &fakeConn{}))
+	if j := jid.New(props.Header.To); j.Resource() == "balcony" || props.Status&stream.Bind == 0 {
+		t.Error("Should assign a server modified resource.")
+		t.Errorf("\nGot :%s", props.Header.To)
+	}
+}
+
+func TestRegistryReplaceBlocked(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry(Replace)
+	old := &blockingConn{writing: make(chan struct{}), closed: make(chan struct{})}
+	j := jid.New("juliet@localhost/balcony")
+	if _, err := r.Bind(j, authenticated("1", old)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		r.Bind(j, authenticated("2", &fakeConn{}))
+		close(done)
+	}()
+	<-old.writing
+
+	// Should not hold the lock while writing to the replaced session.
+	bound := make(chan bool)
+	go func() { bound <- r.Bound(j) }()
+	select {
+	case <-bound:
+	case <-time.After(time.Second):
+		t.Fatal("Should not hold the lock while writing to the replaced session.")
+	}
+	old.Close()
+	<-done
+}
+
+func TestHandlerErrors(t *testing.T) {
+	t.Parallel()
+
+	h := NewHandler().SetRegistry(NewRegistry(Reject).SetMaxResources(1))
+	condition := func(sts []stanza.Stanza) string {
+		if len(sts) != 1 || sts[0].Type != string(stanza.IQError) {
+			return ""
+		}
+		return sts[0].First().ChildElements()[0].Tag
+	}
+
+	// Should reject resources not allowed by the OpaqueString profile.
+	sts, _ := h.HandleIQ(bindIQ("\u0007bell"), authenticated("1", &fakeConn{}))
+	if got := condition(sts); got != "bad-request" {
+		t.Errorf("\nWant:%s\nGot :%s", "bad-request", got)
+	}
+
+	// Should not allow unauthenticated streams to bind.
+	sts, _ = h.HandleIQ(bindIQ("balcony"), stream.Properties{Domain: "localhost"})
+	if got := condition(sts); got != "not-allowed" {
+		t.Errorf("\nWant:%s\nGot :%s", "not-allowed", got)
+	}
+
+	// Should store the full JID in the properties.
+	sts, props := h.HandleIQ(bindIQ("balcony"), authenticated("2", &fakeConn{}))
+	if props.JID != jid.New("juliet@localhost/balcony") {
+		t.Error("Should store the full JID in the properties.")
+		t.Errorf("\nWant:%s\nGot :%s", "juliet@localhost/balcony", props.JID)
+	}
+
+	// Should limit the number of resources per account.
+	sts, _ = h.HandleIQ(bindIQ("orchard"), authenticated("3", &fakeConn{}))
+	if got := condition(sts); got != "resource-constraint" {
+		t.Errorf("\nWant:%s\nGot :%s", "resource-constraint", got)
+	}
+	h.registry.HandleClose(props)
+	sts, _ = h.HandleIQ(bindIQ("orchard"), authenticated("3", &fakeConn{}))
+	if condition(sts) != "" {
+		t.Error("Should allow binding after a resource is released.")
+	}
+}
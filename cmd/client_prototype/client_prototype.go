@@ -7,7 +7,9 @@ import (
 	"net"
 	"os"
 
+	"github.com/skriptble/nine/bind"
 	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/sasl"
 	"github.com/skriptble/nine/stream"
 	"github.com/skriptble/nine/stream/transport"
 )
@@ -48,9 +50,33 @@ func main() {
 		panic(err)
 	}
 
+	creds := sasl.Credentials{Username: "client", Password: "password"}
+	saslHandler := sasl.NewClientHandler(
+		sasl.NewSCRAMClient(sasl.SHA256, true, creds),
+		sasl.NewSCRAMClient(sasl.SHA256, false, creds),
+		sasl.NewSCRAMClient(sasl.SHA1, false, creds),
+		sasl.NewPlainClient(creds),
+	)
+
+	bindHandler := bind.NewClientHandler("prototype")
+
 	tsp := transport.NewTCP(conn, stream.Initiating, config, true)
-	fm := stream.NewFeaturesMux()
-	strm := stream.New(tsp, fm, stream.Initiating)
+	fm := stream.NewFeaturesMux().
+		Handle(namespace.SASL, "mechanisms", 10, saslHandler).
+		Handle(namespace.Bind, "bind", 5, bindHandler)
+	if fm.Err() != nil {
+		panic(fm.Err())
+	}
+	em := stream.NewElementMux().
+		Handle(namespace.Stream, "features", fm).
+		Handle(namespace.SASL, "challenge", saslHandler).
+		Handle(namespace.SASL, "success", saslHandler).
+		Handle(namespace.SASL, "failure", saslHandler).
+		Handle(namespace.Client, "iq", bindHandler)
+	if em.Err() != nil {
+		panic(em.Err())
+	}
+	strm := stream.New(tsp, em, stream.Initiating)
 	strm.Header = stream.Header{
 		From:      "client@localhost",
 		To:        "localhost",
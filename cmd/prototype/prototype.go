@@ -52,6 +52,13 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	saslHandler := sasl.NewHandler(map[string]sasl.Mechanism{
+		"PLAIN": sasl.NewPlainMechanism(sasl.FakePlain{}),
+	})
+	registry := bind.NewRegistry(bind.Replace)
+	bindHandler := bind.NewHandler().SetRegistry(registry)
+	sasl2Handler := sasl.NewSASL2Handler(saslHandler).
+		Inline(namespace.Bind2, "bind", bind.NewBind2(bindHandler))
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
@@ -59,10 +66,6 @@ func main() {
 			continue
 		}
 
-		saslHandler := sasl.NewHandler(map[string]sasl.Mechanism{
-			"PLAIN": sasl.NewPlainMechanism(sasl.FakePlain{}),
-		})
-		bindHandler := bind.NewHandler()
 		sessionHandler := bind.NewSessionHandler()
 		iqHandler := stream.NewIQMux().
 			Handle(namespace.Bind, "bind", string(stanza.IQSet), bindHandler).
@@ -75,6 +78,10 @@ func main() {
 		elHandler := stream.NewElementMux().
 			Handle(namespace.SASL, "auth", saslHandler).
 			Handle(namespace.SASL, "response", saslHandler).
+			Handle(namespace.SASL, "abort", saslHandler).
+			Handle(namespace.SASL2, "authenticate", sasl2Handler).
+			Handle(namespace.SASL2, "response", sasl2Handler).
+			Handle(namespace.SASL2, "abort", sasl2Handler).
 			Handle(namespace.Client, "iq", iqHandler).
 			Handle(namespace.Client, "presence", stream.Blackhole{}).
 			Handle(namespace.Client, "message", stream.Blackhole{})
@@ -85,6 +92,7 @@ func main() {
 
 		fhs := []stream.FeatureGenerator{
 			saslHandler,
+			sasl2Handler,
 			bindHandler,
 			// sessionHandler,
 		}
@@ -94,6 +102,7 @@ func main() {
 		props.Domain = "localhost"
 		s := stream.New(tp, elHandler, stream.Receiving).
 			AddFeatureHandlers(fhs...).
+			AddCloseHandlers(registry).
 			SetProperties(props)
 		go s.Run()
 	}
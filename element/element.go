@@ -1,9 +1,6 @@
 package element
 
 import (
-	"bufio"
-	"bytes"
-	"io"
 	"strings"
 )
 
@@ -18,7 +15,7 @@ var NoElementExists = Element{}
 // Token is an interface implemented by things that can be a child of an
 // element.
 type Token interface {
-	write(w *bufio.Writer)
+	appendTo(dst []byte) []byte
 }
 
 // Element represents an XML element.
@@ -39,29 +36,65 @@ func New(tag string) Element {
 
 // AddAttr creates an Attr and appends it to the element. The key is decomposed
 // into a space and key if it contains a colon:
+//
+// Like the other builder methods, AddAttr does not modify the element it is
+// called on or share memory with it, so elements can be safely derived from
+// package level prototypes by multiple goroutines.
 func (e Element) AddAttr(key, value string) Element {
 	space, key := decompose(key)
 	if space == "xmlns" {
 		// Add this namespace to the namespace map so MatchNamespace will find
 		// it.
-		e.Namespaces[key] = value
+		e = e.AddNamespace(key, value)
 	}
 	if key == "xmlns" {
 		// Change the element's namespace
-		e.Namespaces[""] = value
+		e = e.AddNamespace("", value)
 	}
 	attr := Attr{Key: key, Space: space, Value: value}
-	e.Attr = append(e.Attr, attr)
+	attrs := make([]Attr, len(e.Attr), len(e.Attr)+1)
+	copy(attrs, e.Attr)
+	e.Attr = append(attrs, attr)
 	return e
 }
 
 // AddNamespace adds a namespace to the given element. This should be used
 // instead of AddAttr so that the MatchNamespace function will pick it up.
 func (e Element) AddNamespace(key, value string) Element {
-	if e.Namespaces == nil {
-		e.Namespaces = make(map[string]string)
+	ns := make(map[string]string, len(e.Namespaces)+1)
+	for k, v := range e.Namespaces {
+		ns[k] = v
+	}
+	ns[key] = value
+	e.Namespaces = ns
+	return e
+}
+
+// Clone returns a deep copy of the element. The copy shares no memory with
+// the original, so either can be modified without affecting the other.
+func (e Element) Clone() Element {
+	if e.Namespaces != nil {
+		ns := make(map[string]string, len(e.Namespaces))
+		for k, v := range e.Namespaces {
+			ns[k] = v
+		}
+		e.Namespaces = ns
+	}
+	if e.Attr != nil {
+		attrs := make([]Attr, len(e.Attr))
+		copy(attrs, e.Attr)
+		e.Attr = attrs
+	}
+	if e.Child != nil {
+		children := make([]Token, len(e.Child))
+		for i, c := range e.Child {
+			if el, ok := c.(Element); ok {
+				c = el.Clone()
+			}
+			children[i] = c
+		}
+		e.Child = children
 	}
-	e.Namespaces[key] = value
 	return e
 }
 
@@ -79,55 +112,106 @@ type Attr struct {
 
 // CharData represents the character data of an XML element.
 type CharData struct {
-	Data       string
+	Data string
+	// whitespace is set when the data only contains XML whitespace, which is
+	// usually formatting between child elements.
 	whitespace bool
 }
 
-// WriteTo implements io.WriterTo
-func (e Element) WriteTo(w io.Writer) (n int64, err error) {
-	cw := newCountWriter(w)
-	b := bufio.NewWriter(cw)
-	e.write(b)
-	err = b.Flush()
-	return cw.bytes, err
+// NewCharData creates a CharData with the data and records whether it only
+// contains whitespace.
+func NewCharData(data string) CharData {
+	return CharData{Data: data, whitespace: isSpace(data)}
 }
 
-// WriteBytes serializes the Element into a slice of bytes.
-func (e Element) WriteBytes() []byte {
-	var buf bytes.Buffer
-	e.WriteTo(&buf)
-	return buf.Bytes()
+// IsWhitespace reports whether the character data only contains XML
+// whitespace.
+func (c CharData) IsWhitespace() bool {
+	return c.whitespace || isSpace(c.Data)
 }
 
-// String implements the fmt.Stringer interface.
-func (e Element) String() string {
-	return string(e.WriteBytes())
+// isSpace reports whether s only contains the XML whitespace characters.
+func isSpace(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return false
+		}
+	}
+	return true
 }
 
-// Text returns the text data of the element.
+// Text returns the text data of the element. If the text is split into
+// several CharData children, for example by child elements, the data of all
+// of them is concatenated.
 func (e Element) Text() string {
-	if len(e.Child) == 0 {
-		return ""
+	var first string
+	var n, size int
+	for _, c := range e.Child {
+		if cd, ok := c.(CharData); ok {
+			if n == 0 {
+				first = cd.Data
+			}
+			n++
+			size += len(cd.Data)
+		}
 	}
-	if cd, ok := e.Child[0].(CharData); ok {
-		return cd.Data
+	if n < 2 {
+		return first
 	}
-
-	return ""
+	var text strings.Builder
+	text.Grow(size)
+	for _, c := range e.Child {
+		if cd, ok := c.(CharData); ok {
+			text.WriteString(cd.Data)
+		}
+	}
+	return text.String()
 }
 
-// SetText sets the text data of the element.
+// SetText sets the text data of the element. All existing CharData children
+// are replaced by a single CharData before the child elements.
 func (e Element) SetText(text string) Element {
-	if len(e.Child) > 0 {
-		if cd, ok := e.Child[0].(CharData); ok {
-			cd.Data = text
-			e.Child[0] = cd
-			return e
+	children := make([]Token, 1, len(e.Child)+1)
+	children[0] = NewCharData(text)
+	for _, c := range e.Child {
+		if _, ok := c.(CharData); !ok {
+			children = append(children, c)
+		}
+	}
+	e.Child = children
+	return e
+}
+
+// StripWhitespace returns a copy of the element without whitespace-only
+// character data in elements that have child elements. Text in elements
+// without child elements is kept, even if it is only whitespace.
+func (e Element) StripWhitespace() Element {
+	var hasElements bool
+	for _, c := range e.Child {
+		if _, ok := c.(Element); ok {
+			hasElements = true
+			break
+		}
+	}
+	if !hasElements {
+		return e
+	}
+	children := make([]Token, 0, len(e.Child))
+	for _, c := range e.Child {
+		switch t := c.(type) {
+		case Element:
+			children = append(children, t.StripWhitespace())
+		case CharData:
+			if !t.IsWhitespace() {
+				children = append(children, t)
+			}
+		default:
+			children = append(children, c)
 		}
 	}
-	e.Child = append(e.Child, nil)
-	copy(e.Child[1:], e.Child[0:])
-	e.Child[0] = CharData{Data: text}
+	e.Child = children
 	return e
 }
 
@@ -169,7 +253,9 @@ func (e Element) ChildElements() (elements []Element) {
 
 // AddChild adds the element to the parent's children.
 func (e Element) AddChild(el Element) Element {
-	e.Child = append(e.Child, el)
+	children := make([]Token, len(e.Child), len(e.Child)+1)
+	copy(children, e.Child)
+	e.Child = append(children, el)
 	return e
 }
 
@@ -187,8 +273,12 @@ func (e Element) SelectElement(tag string) Element {
 }
 
 // MatchNamespace returns true if the namespace for this element matches the
-// namespace provided.
+// namespace provided. Elements decoded by a transport have the namespace
+// itself as their space, so this also matches those.
 func (e Element) MatchNamespace(ns string) bool {
+	if e.Space != "" && e.Space == ns {
+		return true
+	}
 	elNS, ok := e.Namespaces[e.Space]
 	if !ok {
 		return false
@@ -196,49 +286,6 @@ func (e Element) MatchNamespace(ns string) bool {
 	return elNS == ns
 }
 
-func (e Element) write(w *bufio.Writer) {
-	w.WriteByte('<')
-	if e.Space != "" {
-		w.WriteString(e.Space)
-		w.WriteByte(':')
-	}
-	w.WriteString(e.Tag)
-	for _, a := range e.Attr {
-		w.WriteByte(' ')
-		a.write(w)
-	}
-	if len(e.Child) > 0 {
-		w.WriteByte('>')
-		for _, c := range e.Child {
-			c.write(w)
-		}
-		w.Write([]byte{'<', '/'})
-		if e.Space != "" {
-			w.WriteString(e.Space)
-			w.WriteByte(':')
-		}
-		w.WriteString(e.Tag)
-		w.WriteByte('>')
-	} else {
-		w.Write([]byte{'/', '>'})
-	}
-}
-
-func (a Attr) write(w *bufio.Writer) {
-	if a.Space != "" {
-		w.WriteString(a.Space)
-		w.WriteByte(':')
-	}
-	w.WriteString(a.Key)
-	w.Write([]byte{'=', '\''})
-	w.WriteString(escape(a.Value))
-	w.WriteByte('\'')
-}
-
-func (c CharData) write(w *bufio.Writer) {
-	w.WriteString(escape(c.Data))
-}
-
 func decompose(str string) (space, key string) {
 	strs := strings.SplitN(str, ":", 2)
 	if (len(strs)) < 2 {
@@ -248,18 +295,6 @@ func decompose(str string) (space, key string) {
 	return strs[0], strs[1]
 }
 
-var xmlReplacer = strings.NewReplacer(
-	"<", "&lt;",
-	">", "&gt;",
-	"&", "&amp;",
-	"'", "&apos;",
-	`"`, "&quot;",
-)
-
-func escape(s string) string {
-	return xmlReplacer.Replace(s)
-}
-
 func equalSpace(a, b string) bool {
 	if a == "" {
 		return true
@@ -267,20 +302,3 @@ func equalSpace(a, b string) bool {
 
 	return a == b
 }
-
-// countWriter is used to count the number of bytes written to an underlying
-// io.Writer.
-type countWriter struct {
-	w     io.Writer
-	bytes int64
-}
-
-func newCountWriter(w io.Writer) *countWriter {
-	return &countWriter{w: w}
-}
-
-func (cw *countWriter) Write(p []byte) (n int, err error) {
-	b, err := cw.w.Write(p)
-	cw.bytes += int64(b)
-	return b, err
-}
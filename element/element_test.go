@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 )
 
@@ -115,6 +117,31 @@ func TestElementText(t *testing.T) {
 		t.Error("Should return empty text if there are no children.")
 		t.Errorf("\nWant:%s\nGot :%s", want, got)
 	}
+
+	// Should concatenate the data of all CharData children.
+	el = Element{Tag: "foo", Child: []Token{CharData{Data: "bar"}, Element{Tag: "qux"}, CharData{Data: "baz"}}}
+	want = "barbaz"
+	got = el.Text()
+	if want != got {
+		t.Error("Should concatenate the data of all CharData children.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+}
+
+func TestElementTextAllocs(t *testing.T) {
+	// Should not allocate for a single CharData child.
+	el := Element{Tag: "foo", Child: []Token{CharData{Data: "barbaz"}}}
+	if allocs := testing.AllocsPerRun(10, func() { el.Text() }); allocs != 0 {
+		t.Errorf("\nWant:%d allocations\nGot :%v", 0, allocs)
+	}
+
+	// Should allocate once for several CharData children.
+	for i := 0; i < 100; i++ {
+		el.Child = append(el.Child, Element{Tag: "bar"}, CharData{Data: "baz"})
+	}
+	if allocs := testing.AllocsPerRun(10, func() { el.Text() }); allocs != 1 {
+		t.Errorf("\nWant:%d allocations\nGot :%v", 1, allocs)
+	}
 }
 
 func TestElementSetText(t *testing.T) {
@@ -329,8 +356,97 @@ func TestMatchNamespace(t *testing.T) {
 	if got != want {
 		t.Error("Should return true if namespace default matches")
 	}
+	// Should return true if the space is the namespace
+	want = true
+	ns = "http://foo.bar"
+	el = Element{Space: ns, Tag: "bar"}
+	got = el.MatchNamespace(ns)
+	if got != want {
+		t.Error("Should return true if the space is the namespace")
+	}
 }
 
 type errWriter struct{ err error }
 
 func (ew errWriter) Write(_ []byte) (int, error) { return 0, ew.err }
+
+func TestCopyOnWrite(t *testing.T) {
+	t.Parallel()
+
+	// Should not modify the prototype or its siblings.
+	base := New("error").AddChild(New("a"))
+	x := base.AddChild(New("x")).AddAttr("xmlns:foo", "urn:foo")
+	y := base.AddChild(New("y"))
+	if len(base.Child) != 1 || len(base.Namespaces) != 0 {
+		t.Error("Should not modify the prototype.")
+		t.Errorf("\nGot :%s %v", base, base.Namespaces)
+	}
+	if x.Child[1].(Element).Tag != "x" || y.Child[1].(Element).Tag != "y" {
+		t.Error("Should not share children between derived elements.")
+		t.Errorf("\nGot :%s %s", x, y)
+	}
+	text := base.SetText("hello")
+	if base.Text() != "" || text.Text() != "hello" {
+		t.Error("Should not modify the prototype when setting text.")
+	}
+
+	// Should deep copy elements.
+	clone := x.Clone()
+	clone.Namespaces["foo"] = "urn:bar"
+	if x.Namespaces["foo"] != "urn:foo" {
+		t.Error("Should deep copy elements.")
+	}
+
+	// Should be safe to derive from prototypes concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			StreamError.Conflict.AddChild(New("text").SetText(strconv.Itoa(i))).AddAttr("xmlns:x", "urn:x")
+			Bind.AddAttr("xmlns:y", "urn:y")
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestMixedContent(t *testing.T) {
+	t.Parallel()
+
+	body := Element{Tag: "body", Child: []Token{
+		CharData{Data: "Romeo "}, CharData{Data: "&"}, CharData{Data: " Juliet "},
+		New("em").SetText("forever"),
+		CharData{Data: "!"},
+	}}
+
+	// Should concatenate all text.
+	if got := body.Text(); got != "Romeo & Juliet !" {
+		t.Error("Should concatenate all text.")
+		t.Errorf("\nWant:%s\nGot :%s", "Romeo & Juliet !", got)
+	}
+
+	// Should replace all text when setting it.
+	if got := body.SetText("Hello").String(); got != "<body>Hello<em>forever</em></body>" {
+		t.Error("Should replace all text when setting it.")
+		t.Errorf("\nGot :%s", got)
+	}
+
+	// Should detect and strip whitespace.
+	el := New("iq").AddChild(New("query")).AddChild(New("x").SetText("  "))
+	el.Child = append([]Token{NewCharData("\n  ")}, el.Child...)
+	if !el.Child[0].(CharData).IsWhitespace() || !(CharData{Data: " \t"}).IsWhitespace() {
+		t.Error("Should detect whitespace-only character data.")
+	}
+	if got := el.StripWhitespace().String(); got != "<iq><query/><x>  </x></iq>" {
+		t.Error("Should strip whitespace between child elements only.")
+		t.Errorf("\nGot :%s", got)
+	}
+
+	// Should indent child elements but not text.
+	want := "<message>\n  <body>Hi <em>there</em></body>\n  <thread/>\n</message>"
+	msg := New("message").AddChild(New("body").SetText("Hi ").AddChild(New("em").SetText("there"))).AddChild(New("thread"))
+	if got := msg.Indent("  "); got != want {
+		t.Error("Should indent child elements but not text.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+}
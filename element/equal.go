@@ -0,0 +1,235 @@
+package element
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Equal reports whether a and b are the same XML. Elements and attributes are
+// compared by namespace rather than prefix, attribute order and namespace
+// declarations are ignored, and whitespace-only text between child elements
+// is treated as insignificant. It is equivalent to comparing the canonical
+// serializations of a and b.
+func Equal(a, b Element) bool {
+	return bytes.Equal(Canonical(a), Canonical(b))
+}
+
+// Canonical returns a canonical serialization of the element. Two elements
+// that are Equal have the same canonical serialization. Every element is
+// written without a prefix and declares its namespace when it differs from
+// its parent's, attributes are sorted by namespace and key, adjacent text is
+// merged and whitespace-only text between child elements is dropped.
+func Canonical(e Element) []byte {
+	var buf bytes.Buffer
+	writeCanonical(&buf, e, nil, "", -1)
+	return buf.Bytes()
+}
+
+// canonicalAttr is an attribute with its namespace resolved.
+type canonicalAttr struct {
+	space, key, value string
+}
+
+// writeCanonical writes the canonical serialization of e. If depth is not
+// negative the output is indented with one element or text node per line.
+func writeCanonical(buf *bytes.Buffer, e Element, parent map[string]string, parentNS string, depth int) {
+	ns := scope(parent, e)
+	space := elementNamespace(e, ns)
+
+	newline(buf, depth)
+	buf.WriteByte('<')
+	buf.WriteString(e.Tag)
+	if space != parentNS {
+		buf.WriteString(" xmlns='")
+		buf.WriteString(escape(space))
+		buf.WriteByte('\'')
+	}
+
+	var attrs []canonicalAttr
+	for _, a := range e.Attr {
+		if (a.Space == "" && a.Key == "xmlns") || a.Space == "xmlns" {
+			continue
+		}
+		attrs = append(attrs, canonicalAttr{space: attrNamespace(a, ns), key: a.Key, value: a.Value})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].key < attrs[j].key
+	})
+	prefixes := make(map[string]string)
+	for _, a := range attrs {
+		if a.space == "" || a.space == xmlURL {
+			continue
+		}
+		if _, ok := prefixes[a.space]; !ok {
+			prefixes[a.space] = "a" + strconv.Itoa(len(prefixes))
+			buf.WriteString(" xmlns:" + prefixes[a.space] + "='")
+			buf.WriteString(escape(a.space))
+			buf.WriteByte('\'')
+		}
+	}
+	for _, a := range attrs {
+		buf.WriteByte(' ')
+		switch {
+		case a.space == xmlURL:
+			buf.WriteString("xml:")
+		case a.space != "":
+			buf.WriteString(prefixes[a.space] + ":")
+		}
+		buf.WriteString(a.key)
+		buf.WriteString("='")
+		buf.WriteString(escape(a.value))
+		buf.WriteByte('\'')
+	}
+
+	children := canonicalChildren(e)
+	if len(children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteByte('>')
+	for _, c := range children {
+		switch t := c.(type) {
+		case Element:
+			writeCanonical(buf, t, ns, space, nextDepth(depth))
+		case CharData:
+			newline(buf, nextDepth(depth))
+			buf.WriteString(escape(t.Data))
+		}
+	}
+	newline(buf, depth)
+	buf.WriteString("</")
+	buf.WriteString(e.Tag)
+	buf.WriteByte('>')
+}
+
+// canonicalChildren merges adjacent text and drops whitespace-only text if
+// the element has child elements.
+func canonicalChildren(e Element) []Token {
+	var children []Token
+	var hasElements bool
+	for _, c := range e.Child {
+		switch t := c.(type) {
+		case Element:
+			hasElements = true
+			children = append(children, t)
+		case CharData:
+			if n := len(children); n > 0 {
+				if prev, ok := children[n-1].(CharData); ok {
+					children[n-1] = CharData{Data: prev.Data + t.Data}
+					continue
+				}
+			}
+			children = append(children, t)
+		}
+	}
+	if !hasElements {
+		return children
+	}
+	filtered := children[:0]
+	for _, c := range children {
+		if cd, ok := c.(CharData); ok && isSpace(cd.Data) {
+			continue
+		}
+		filtered = append(filtered, c)
+	}
+	return filtered
+}
+
+// elementNamespace returns the namespace URI of e given the namespaces in
+// scope.
+func elementNamespace(e Element, ns map[string]string) string {
+	switch {
+	case e.Space == "":
+		return ns[""]
+	case strings.ContainsAny(e.Space, ":/"):
+		return e.Space
+	}
+	return resolvePrefix(e.Space, ns)
+}
+
+// attrNamespace returns the namespace URI of a given the namespaces in scope.
+// Attributes without a prefix are in no namespace.
+func attrNamespace(a Attr, ns map[string]string) string {
+	switch {
+	case a.Space == "":
+		return ""
+	case a.Space == "xml" || a.Space == xmlURL:
+		return xmlURL
+	case strings.ContainsAny(a.Space, ":/"):
+		return a.Space
+	}
+	return resolvePrefix(a.Space, ns)
+}
+
+func newline(buf *bytes.Buffer, depth int) {
+	if depth < 0 {
+		return
+	}
+	if buf.Len() > 0 {
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(strings.Repeat("  ", depth))
+}
+
+func nextDepth(depth int) int {
+	if depth < 0 {
+		return depth
+	}
+	return depth + 1
+}
+
+// Diff returns a human readable, line based diff of the canonical forms of
+// want and got, or an empty string if they are Equal. Lines only in want are
+// prefixed with -, lines only in got with +. It is meant for test failure
+// messages.
+func Diff(want, got Element) string {
+	if Equal(want, got) {
+		return ""
+	}
+	var wb, gb bytes.Buffer
+	writeCanonical(&wb, want, nil, "", 0)
+	writeCanonical(&gb, got, nil, "", 0)
+	a := strings.Split(wb.String(), "\n")
+	b := strings.Split(gb.String(), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out.WriteString("  " + a[i] + "\n")
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			out.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,48 @@
+package element
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	t.Parallel()
+
+	a := New("message").AddAttr("xmlns", "jabber:client").AddAttr("to", "juliet@example.com").AddAttr("type", "chat").
+		AddChild(New("body").SetText("Art thou not Romeo?"))
+	decoded := Element{Space: "jabber:client", Tag: "message", Attr: []Attr{
+		{Key: "type", Value: "chat"},
+		{Key: "to", Value: "juliet@example.com"},
+	}, Child: []Token{
+		CharData{Data: "\n  "},
+		Element{Space: "jabber:client", Tag: "body", Child: []Token{CharData{Data: "Art thou "}, CharData{Data: "not Romeo?"}}},
+		CharData{Data: "\n"},
+	}}
+
+	// Should ignore attribute order, prefixes and insignificant whitespace.
+	if !Equal(a, decoded) {
+		t.Error("Should ignore attribute order, prefixes and insignificant whitespace.")
+		t.Errorf("\nWant:%s\nGot :%s", Canonical(a), Canonical(decoded))
+	}
+
+	// Should compare namespaces.
+	other := New("message").AddAttr("xmlns", "jabber:server").AddAttr("to", "juliet@example.com").AddAttr("type", "chat").
+		AddChild(New("body").SetText("Art thou not Romeo?"))
+	if Equal(a, other) {
+		t.Error("Should compare namespaces.")
+	}
+
+	// Should only ignore XML whitespace.
+	nbsp := Element{Tag: "message", Child: []Token{New("body"), CharData{Data: "\u00a0"}}}
+	if Equal(nbsp, New("message").AddChild(New("body"))) {
+		t.Error("Should only ignore XML whitespace.")
+	}
+
+	// Should produce a readable diff.
+	changed := a.AddChild(New("thread").SetText("e0ffe42b28561960c6b12b944a092794b9683a38"))
+	diff := Diff(a, changed)
+	if !strings.Contains(diff, "+   <thread>") || Diff(a, decoded) != "" {
+		t.Error("Should produce a readable diff.")
+		t.Errorf("\nGot :\n%s", diff)
+	}
+}
@@ -0,0 +1,58 @@
+package element
+
+import (
+	"io"
+	"strings"
+)
+
+// WriteIndentTo writes the element to w with each child element on its own
+// line, indented by indent for each level. It is meant for logs and debugging.
+// Whitespace-only character data is dropped and elements that contain text
+// are written on a single line, so their text is not changed.
+func (e Element) WriteIndentTo(w io.Writer, indent string) (n int64, err error) {
+	bp := bufPool.Get().(*[]byte)
+	b := e.StripWhitespace().appendIndent((*bp)[:0], indent, 0)
+	written, err := w.Write(b)
+	if cap(b) <= maxPooledBuffer {
+		*bp = b
+		bufPool.Put(bp)
+	}
+	return int64(written), err
+}
+
+// Indent returns the element serialized by WriteIndentTo.
+func (e Element) Indent(indent string) string {
+	return string(e.StripWhitespace().appendIndent(nil, indent, 0))
+}
+
+func (e Element) appendIndent(dst []byte, indent string, depth int) []byte {
+	for _, c := range e.Child {
+		if _, ok := c.(CharData); ok {
+			// Indenting would add whitespace to the text.
+			return e.appendTo(dst)
+		}
+	}
+	if len(e.Child) == 0 {
+		return e.appendTo(dst)
+	}
+
+	dst = append(dst, '<')
+	dst = e.appendName(dst)
+	for _, a := range e.Attr {
+		dst = append(dst, ' ')
+		dst = a.appendTo(dst)
+	}
+	dst = append(dst, '>')
+	for _, c := range e.Child {
+		if el, ok := c.(Element); ok {
+			dst = append(dst, '\n')
+			dst = append(dst, strings.Repeat(indent, depth+1)...)
+			dst = el.appendIndent(dst, indent, depth+1)
+		}
+	}
+	dst = append(dst, '\n')
+	dst = append(dst, strings.Repeat(indent, depth)...)
+	dst = append(dst, '<', '/')
+	dst = e.appendName(dst)
+	return append(dst, '>')
+}
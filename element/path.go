@@ -0,0 +1,289 @@
+package element
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidPath is returned when a path cannot be compiled.
+var ErrInvalidPath = errors.New("invalid element path")
+
+// Path is a compiled query that selects descendants of an element. A path is
+// a list of steps separated by slashes, starting at the children of the
+// element it is applied to. Each step matches child elements by tag and can
+// be preceded by a namespace in braces and followed by attribute predicates:
+//
+//	bind/jid
+//	{urn:xmpp:mam:2}result/forwarded/message/body
+//	query/item[@jid='juliet@example.com']
+//	*/x[@type]
+//
+// A tag of * matches any element. Steps without a namespace match elements in
+// any namespace. Namespaces are resolved through the xmlns attributes of
+// ancestors, so elements created with New and elements decoded by a transport
+// are matched the same way.
+type Path struct {
+	steps []step
+}
+
+type step struct {
+	space, tag string
+	preds      []predicate
+}
+
+// predicate matches an attribute. If hasValue is false the attribute only
+// has to exist.
+type predicate struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// CompilePath compiles the path so it can be applied to elements.
+func CompilePath(path string) (Path, error) {
+	var p Path
+	for path != "" {
+		var s step
+		var err error
+		s, path, err = parseStep(path)
+		if err != nil {
+			return Path{}, err
+		}
+		p.steps = append(p.steps, s)
+		if path != "" {
+			if path[0] != '/' || len(path) == 1 {
+				return Path{}, fmt.Errorf("%w: expected step after '/'", ErrInvalidPath)
+			}
+			path = path[1:]
+		}
+	}
+	if len(p.steps) == 0 {
+		return Path{}, fmt.Errorf("%w: empty path", ErrInvalidPath)
+	}
+	return p, nil
+}
+
+// MustCompilePath is like CompilePath but panics if the path is invalid. It
+// is meant for package level variables.
+func MustCompilePath(path string) Path {
+	p, err := CompilePath(path)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func parseStep(path string) (s step, rest string, err error) {
+	if strings.HasPrefix(path, "{") {
+		end := strings.IndexByte(path, '}')
+		if end == -1 {
+			return s, "", fmt.Errorf("%w: unterminated namespace", ErrInvalidPath)
+		}
+		s.space, path = path[1:end], path[end+1:]
+	}
+	end := strings.IndexAny(path, "/[")
+	if end == -1 {
+		end = len(path)
+	}
+	s.tag, path = path[:end], path[end:]
+	if s.tag == "" {
+		return s, "", fmt.Errorf("%w: empty tag", ErrInvalidPath)
+	}
+	for strings.HasPrefix(path, "[") {
+		var pred predicate
+		pred, path, err = parsePredicate(path)
+		if err != nil {
+			return s, "", err
+		}
+		s.preds = append(s.preds, pred)
+	}
+	return s, path, nil
+}
+
+// parsePredicate parses a predicate of the form [@key] or [@key='value'].
+func parsePredicate(path string) (pred predicate, rest string, err error) {
+	if !strings.HasPrefix(path, "[@") {
+		return pred, "", fmt.Errorf("%w: predicates must start with [@", ErrInvalidPath)
+	}
+	path = path[2:]
+	end := strings.IndexAny(path, "=]")
+	if end <= 0 {
+		return pred, "", fmt.Errorf("%w: invalid predicate", ErrInvalidPath)
+	}
+	pred.key, path = path[:end], path[end:]
+	if path[0] == '=' {
+		if len(path) < 2 || (path[1] != '\'' && path[1] != '"') {
+			return pred, "", fmt.Errorf("%w: predicate value must be quoted", ErrInvalidPath)
+		}
+		quote := path[1]
+		path = path[2:]
+		end = strings.IndexByte(path, quote)
+		if end == -1 {
+			return pred, "", fmt.Errorf("%w: unterminated predicate value", ErrInvalidPath)
+		}
+		pred.value, pred.hasValue, path = path[:end], true, path[end+1:]
+	}
+	if !strings.HasPrefix(path, "]") {
+		return pred, "", fmt.Errorf("%w: unterminated predicate", ErrInvalidPath)
+	}
+	return pred, path[1:], nil
+}
+
+// First returns the first element selected by the path, or NoElementExists.
+func (p Path) First(e Element) Element {
+	var found Element
+	p.match(e, scope(nil, e), 0, func(el Element) bool {
+		found = el
+		return false
+	})
+	if found.Tag == "" {
+		return NoElementExists
+	}
+	return found
+}
+
+// All returns every element selected by the path in document order.
+func (p Path) All(e Element) []Element {
+	var found []Element
+	p.match(e, scope(nil, e), 0, func(el Element) bool {
+		found = append(found, el)
+		return true
+	})
+	return found
+}
+
+// match applies the steps from i onwards to the children of e, calling fn for
+// each match. It returns false once fn has returned false.
+func (p Path) match(e Element, ns map[string]string, i int, fn func(Element) bool) bool {
+	s := p.steps[i]
+	for _, c := range e.ChildElements() {
+		cns := scope(ns, c)
+		if !s.matches(c, cns) {
+			continue
+		}
+		if i == len(p.steps)-1 {
+			if !fn(c) {
+				return false
+			}
+			continue
+		}
+		if !p.match(c, cns, i+1, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s step) matches(e Element, ns map[string]string) bool {
+	if s.tag != "*" && s.tag != e.Tag {
+		return false
+	}
+	if s.space != "" && s.space != namespaceOf(e, ns) {
+		return false
+	}
+	for _, pred := range s.preds {
+		a := e.SelectAttr(pred.key)
+		if a == NoAttrExists || (pred.hasValue && a.Value != pred.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// scope returns the namespace declarations in scope for e, given the
+// declarations in scope for its parent. The parent's map is not modified.
+func scope(parent map[string]string, e Element) map[string]string {
+	var ns map[string]string
+	declare := func(prefix, uri string) {
+		if ns == nil {
+			ns = make(map[string]string, len(parent)+1)
+			for k, v := range parent {
+				ns[k] = v
+			}
+		}
+		ns[prefix] = uri
+	}
+	for _, a := range e.Attr {
+		switch {
+		case a.Space == "" && a.Key == "xmlns":
+			declare("", a.Value)
+		case a.Space == "xmlns":
+			declare(a.Key, a.Value)
+		}
+	}
+	for prefix, uri := range e.Namespaces {
+		if ns[prefix] != uri && parent[prefix] != uri {
+			declare(prefix, uri)
+		}
+	}
+	if ns == nil {
+		return parent
+	}
+	return ns
+}
+
+// namespaceOf returns the namespace URI of e. Elements decoded by a transport
+// have the URI as their space.
+func namespaceOf(e Element, ns map[string]string) string {
+	if uri, ok := ns[e.Space]; ok {
+		return uri
+	}
+	return e.Space
+}
+
+// Find returns the first element selected by the path, or NoElementExists if
+// there is none or the path is invalid.
+func (e Element) Find(path string) Element {
+	p, err := CompilePath(path)
+	if err != nil {
+		return NoElementExists
+	}
+	return p.First(e)
+}
+
+// FindAll returns every element selected by the path. It returns nil if the
+// path is invalid.
+func (e Element) FindAll(path string) []Element {
+	p, err := CompilePath(path)
+	if err != nil {
+		return nil
+	}
+	return p.All(e)
+}
+
+// Walk calls fn for e and each of its descendant elements in document order.
+// If fn returns false the children of that element are skipped.
+func (e Element) Walk(fn func(Element) bool) {
+	if !fn(e) {
+		return
+	}
+	for _, c := range e.Child {
+		if el, ok := c.(Element); ok {
+			el.Walk(fn)
+		}
+	}
+}
+
+// Transform rebuilds the element tree, replacing every element with the
+// result of fn. Children are transformed before their parent, so fn sees
+// the already transformed children. If fn returns an element with an empty
+// tag, the element is removed from its parent. The original tree is not
+// modified.
+func (e Element) Transform(fn func(Element) Element) Element {
+	if len(e.Child) > 0 {
+		children := make([]Token, 0, len(e.Child))
+		for _, c := range e.Child {
+			if el, ok := c.(Element); ok {
+				el = el.Transform(fn)
+				if el.Tag == "" {
+					continue
+				}
+				c = el
+			}
+			children = append(children, c)
+		}
+		e.Child = children
+	}
+	return fn(e)
+}
@@ -0,0 +1,103 @@
+package element
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	t.Parallel()
+
+	const mam = "urn:xmpp:mam:2"
+	msg := New("message").AddAttr("xmlns", "jabber:client").
+		AddChild(New("result").AddAttr("xmlns", mam).AddAttr("id", "28482").
+			AddChild(New("forwarded").AddAttr("xmlns", "urn:xmpp:forward:0").
+				AddChild(New("message").AddAttr("xmlns", "jabber:client").
+					AddChild(New("body").SetText("Hail to thee")))))
+
+	// Should follow a namespaced path.
+	got := msg.Find("{" + mam + "}result/forwarded/message/body").Text()
+	if got != "Hail to thee" {
+		t.Error("Should follow a namespaced path.")
+		t.Errorf("\nWant:%s\nGot :%s", "Hail to thee", got)
+	}
+
+	// Should not match elements in another namespace.
+	if el := msg.Find("{urn:xmpp:mam:1}result"); el.Tag != "" {
+		t.Error("Should not match elements in another namespace.")
+	}
+
+	// Should match the namespaces of decoded elements.
+	decoded := Element{Space: "jabber:client", Tag: "iq", Child: []Token{
+		Element{Space: "urn:ietf:params:xml:ns:xmpp-bind", Tag: "bind", Child: []Token{
+			Element{Space: "urn:ietf:params:xml:ns:xmpp-bind", Tag: "jid", Child: []Token{
+				CharData{Data: "juliet@example.com/balcony"},
+			}},
+		}},
+	}}
+	if got := decoded.Find("{urn:ietf:params:xml:ns:xmpp-bind}bind/jid").Text(); got != "juliet@example.com/balcony" {
+		t.Error("Should match the namespaces of decoded elements.")
+		t.Errorf("\nWant:%s\nGot :%s", "juliet@example.com/balcony", got)
+	}
+
+	// Should filter with attribute predicates and return all matches.
+	query := New("query").
+		AddChild(New("item").AddAttr("jid", "juliet@example.com").AddAttr("name", "Juliet")).
+		AddChild(New("item").AddAttr("jid", "romeo@example.net")).
+		AddChild(New("item").AddAttr("jid", "nurse@example.com").AddAttr("name", "Nurse"))
+	wrapper := New("iq").AddChild(query)
+	if all := wrapper.FindAll("query/item[@name]"); len(all) != 2 {
+		t.Error("Should return all matches.")
+		t.Errorf("\nWant:%d\nGot :%d", 2, len(all))
+	}
+	if el := wrapper.Find("query/item[@jid='romeo@example.net']"); el.SelectAttrValue("jid", "") != "romeo@example.net" {
+		t.Error("Should filter with attribute predicates.")
+		t.Errorf("\nGot :%s", el)
+	}
+	if all := wrapper.FindAll("*/*"); len(all) != 3 {
+		t.Error("Should match any tag with *.")
+	}
+
+	// Should reject invalid paths.
+	for _, path := range []string{"", "a//b", "{ns", "a[jid]", "a[@jid='x]", "a/"} {
+		if _, err := CompilePath(path); err == nil {
+			t.Errorf("Should reject invalid path %q.", path)
+		}
+	}
+}
+
+func TestWalkTransform(t *testing.T) {
+	t.Parallel()
+
+	el := New("a").AddChild(New("b").AddChild(New("c"))).AddChild(New("d"))
+
+	// Should visit elements in document order.
+	var tags []string
+	el.Walk(func(e Element) bool {
+		tags = append(tags, e.Tag)
+		return e.Tag != "b"
+	})
+	if got := strings.Join(tags, ","); got != "a,b,d" {
+		t.Error("Should visit elements in document order and skip children.")
+		t.Errorf("\nWant:%s\nGot :%s", "a,b,d", got)
+	}
+
+	// Should rebuild the tree without modifying the original.
+	out := el.Transform(func(e Element) Element {
+		switch e.Tag {
+		case "c":
+			return NoElementExists
+		case "d":
+			return e.SetText("x")
+		}
+		return e
+	})
+	want := "<a><b/><d>x</d></a>"
+	if got := out.String(); got != want {
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+	if got := el.String(); got != "<a><b><c/></b><d/></a>" {
+		t.Error("Should not modify the original tree.")
+		t.Errorf("\nGot :%s", got)
+	}
+}
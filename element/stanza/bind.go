@@ -10,6 +10,10 @@ import (
 
 var ErrNotBindRequest = errors.New("IQ is not a bind request")
 
+// ErrNotBindResult is returned from TransformBindResult if the IQ is not a
+// result containing a bound JID.
+var ErrNotBindResult = errors.New("IQ is not a bind result")
+
 type BindResult struct {
 	IQ
 }
@@ -35,13 +39,42 @@ func NewBindResult(iq IQ, j jid.JID) BindResult {
 	return BindResult{IQ: iq}
 }
 
+// TransformBindRequest extracts the bind request from the IQ. It returns
+// ErrNotBindRequest if the IQ does not contain a bind element.
 func TransformBindRequest(iq IQ) (br BindRequest, err error) {
 	for _, child := range iq.Children {
-		if child.Tag == "bind" && child.Space == namespace.Bind {
+		if child.Tag == "bind" && child.MatchNamespace(namespace.Bind) {
 			br.Resource = child.SelectElement("resource").Text()
-			break
+			return br, nil
 		}
 	}
 
-	return
+	return br, ErrNotBindRequest
+}
+
+// TransformBindResult converts the IQ into a BindResult. It returns
+// ErrNotBindResult if the IQ is not a result or does not contain a jid.
+func TransformBindResult(iq IQ) (BindResult, error) {
+	if iq.Type != string(IQResult) {
+		return BindResult{}, ErrNotBindResult
+	}
+	for _, child := range iq.Children {
+		if child.Tag == "bind" && child.MatchNamespace(namespace.Bind) {
+			if child.SelectElement("jid").Text() == "" {
+				break
+			}
+			return BindResult{IQ: iq}, nil
+		}
+	}
+	return BindResult{}, ErrNotBindResult
+}
+
+// JID returns the JID bound by the receiving entity.
+func (br BindResult) JID() jid.JID {
+	for _, child := range br.Children {
+		if child.Tag == "bind" {
+			return jid.New(child.SelectElement("jid").Text())
+		}
+	}
+	return jid.Empty
 }
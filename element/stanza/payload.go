@@ -0,0 +1,234 @@
+package stanza
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/skriptble/nine/element"
+)
+
+// ErrUnknownPayload is returned when an element or type is not registered as
+// a payload.
+var ErrUnknownPayload = errors.New("payload is not registered")
+
+// ErrPayloadNotFound is returned from Payload if the stanza has no child of
+// the requested type.
+var ErrPayloadNotFound = errors.New("stanza does not contain the payload")
+
+// ErrPayloadNameEmpty is returned from Register if the space or tag is empty.
+var ErrPayloadNameEmpty = errors.New("payload space or tag cannot be empty")
+
+// PayloadName is the namespace and tag of a payload element.
+type PayloadName struct {
+	Space, Tag string
+}
+
+// Registry maps payload elements to Go types. Registered types can be decoded
+// from and encoded to the children of stanzas. Children which are not
+// registered are never decoded, so they are preserved as they were received.
+//
+// A Registry is safe for concurrent use.
+type Registry struct {
+	mu     sync.RWMutex
+	byName map[PayloadName]payloadType
+	byType map[reflect.Type]PayloadName
+}
+
+type payloadType struct {
+	decode func(element.Element) (interface{}, error)
+}
+
+// DefaultRegistry is the Registry used by RegisterPayload.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		byName: make(map[PayloadName]payloadType),
+		byType: make(map[reflect.Type]PayloadName),
+	}
+}
+
+// Register registers typ as the type of the payload with the namespace and
+// tag. The payload is decoded with decode, which must return values of typ.
+// If decode is nil, the payload is decoded with element.Decode into a new
+// value of typ, so typ can be a struct type with xml tags. A name and a type
+// can only be registered once.
+//
+// Payloads are encoded with their TransformElement method if they implement
+// element.Transformer and with element.Encode otherwise.
+func (r *Registry) Register(space, tag string, typ reflect.Type, decode func(element.Element) (interface{}, error)) error {
+	if space == "" || tag == "" {
+		return ErrPayloadNameEmpty
+	}
+	if decode == nil {
+		decode = func(el element.Element) (interface{}, error) {
+			v := reflect.New(typ)
+			err := element.Decode(el, v.Interface())
+			return v.Elem().Interface(), err
+		}
+	}
+	name := PayloadName{Space: space, Tag: tag}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byName[name]; ok {
+		return fmt.Errorf("multiple registrations for payload <%s xmlns='%s'>", tag, space)
+	}
+	if existing, ok := r.byType[typ]; ok {
+		return fmt.Errorf("%s is already registered for <%s xmlns='%s'>", typ, existing.Tag, existing.Space)
+	}
+	r.byName[name] = payloadType{
+		decode: func(el element.Element) (interface{}, error) {
+			v, err := decode(el)
+			if err == nil && reflect.TypeOf(v) != typ {
+				return nil, fmt.Errorf("decoding <%s xmlns='%s'> returned %T instead of %s", tag, space, v, typ)
+			}
+			return v, err
+		},
+	}
+	r.byType[typ] = name
+	return nil
+}
+
+// RegisterPayload registers typ with the DefaultRegistry. It panics if the
+// registration fails, so it is meant to be called from init functions.
+func RegisterPayload(space, tag string, typ reflect.Type, decode func(element.Element) (interface{}, error)) {
+	if err := DefaultRegistry.Register(space, tag, typ, decode); err != nil {
+		panic(err)
+	}
+}
+
+// Names returns the names of the registered payloads sorted by namespace and
+// tag.
+func (r *Registry) Names() []PayloadName {
+	r.mu.RLock()
+	names := make([]PayloadName, 0, len(r.byName))
+	for name := range r.byName {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Space != names[j].Space {
+			return names[i].Space < names[j].Space
+		}
+		return names[i].Tag < names[j].Tag
+	})
+	return names
+}
+
+// Features returns the sorted namespaces of the registered payloads. They can
+// be advertised as service discovery features.
+func (r *Registry) Features() []string {
+	var features []string
+	for _, name := range r.Names() {
+		if len(features) == 0 || features[len(features)-1] != name.Space {
+			features = append(features, name.Space)
+		}
+	}
+	return features
+}
+
+// Registered reports whether a payload is registered for the element.
+func (r *Registry) Registered(el element.Element) bool {
+	_, ok := r.lookup(el)
+	return ok
+}
+
+// Decode decodes the element into the type registered for it. It returns
+// ErrUnknownPayload if no type is registered for the element.
+func (r *Registry) Decode(el element.Element) (interface{}, error) {
+	pt, ok := r.lookup(el)
+	if !ok {
+		return nil, ErrUnknownPayload
+	}
+	return pt.decode(el)
+}
+
+// Encode encodes the registered payload v into an element. It returns
+// ErrUnknownPayload if the type of v is not registered.
+func (r *Registry) Encode(v interface{}) (element.Element, error) {
+	if _, ok := r.name(reflect.TypeOf(v)); !ok {
+		return element.Element{}, ErrUnknownPayload
+	}
+	if t, ok := v.(element.Transformer); ok {
+		return t.TransformElement(), nil
+	}
+	return element.Encode(v)
+}
+
+func (r *Registry) lookup(el element.Element) (payloadType, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	// Elements decoded by a transport have their namespace as their space,
+	// built elements have it in their namespaces.
+	if pt, ok := r.byName[PayloadName{Space: el.Space, Tag: el.Tag}]; ok && el.Space != "" {
+		return pt, true
+	}
+	ns, ok := el.Namespaces[el.Space]
+	if !ok {
+		return payloadType{}, false
+	}
+	pt, ok := r.byName[PayloadName{Space: ns, Tag: el.Tag}]
+	return pt, ok
+}
+
+func (r *Registry) name(typ reflect.Type) (PayloadName, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, ok := r.byType[typ]
+	return name, ok
+}
+
+// Payload decodes the first child of the stanza registered as the type v
+// points to and stores it in v. It returns ErrUnknownPayload if the type is
+// not registered and ErrPayloadNotFound if the stanza has no such child.
+func (s Stanza) Payload(r *Registry, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("Payload needs a non-nil pointer, got %T", v)
+	}
+	name, ok := r.name(rv.Type().Elem())
+	if !ok {
+		return ErrUnknownPayload
+	}
+	for _, child := range s.Children {
+		if child.Tag != name.Tag || !child.MatchNamespace(name.Space) {
+			continue
+		}
+		decoded, err := r.Decode(child)
+		if err != nil {
+			return err
+		}
+		rv.Elem().Set(reflect.ValueOf(decoded))
+		return nil
+	}
+	return ErrPayloadNotFound
+}
+
+// SetPayload encodes the registered payload v and sets it as a child of the
+// stanza. A child with the same namespace and tag is replaced, all other
+// children are kept unchanged.
+func (s Stanza) SetPayload(r *Registry, v interface{}) (Stanza, error) {
+	name, ok := r.name(reflect.TypeOf(v))
+	if !ok {
+		return s, ErrUnknownPayload
+	}
+	el, err := r.Encode(v)
+	if err != nil {
+		return s, err
+	}
+	for i, child := range s.Children {
+		if child.Tag == name.Tag && child.MatchNamespace(name.Space) {
+			children := make([]element.Element, len(s.Children))
+			copy(children, s.Children)
+			children[i] = el
+			s.Children = children
+			return s, nil
+		}
+	}
+	return s.AddChild(el), nil
+}
@@ -0,0 +1,141 @@
+package stanza
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/jid"
+)
+
+type chatState struct {
+	State string
+}
+
+func (c chatState) TransformElement() element.Element {
+	return element.New(c.State).AddAttr("xmlns", "http://jabber.org/protocol/chatstates")
+}
+
+type receipt struct {
+	XMLName xml.Name `xml:"urn:xmpp:receipts request"`
+	ID      string   `xml:"id,attr,omitempty"`
+}
+
+func testRegistry(t *testing.T) *Registry {
+	r := NewRegistry()
+	err := r.Register("http://jabber.org/protocol/chatstates", "active", reflect.TypeOf(chatState{}),
+		func(el element.Element) (interface{}, error) {
+			return chatState{State: el.Tag}, nil
+		})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	err = r.Register("urn:xmpp:receipts", "request", reflect.TypeOf(receipt{}), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return r
+}
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	r := testRegistry(t)
+
+	// Should not register a name or a type twice.
+	if err := r.Register("urn:xmpp:receipts", "received", reflect.TypeOf(receipt{}), nil); err == nil {
+		t.Error("Should not register a type twice.")
+	}
+	if err := r.Register("urn:xmpp:receipts", "request", reflect.TypeOf(chatState{}), nil); err == nil {
+		t.Error("Should not register a name twice.")
+	}
+	if err := r.Register("", "request", reflect.TypeOf(chatState{}), nil); err != ErrPayloadNameEmpty {
+		t.Errorf("\nWant:%s\nGot :%v", ErrPayloadNameEmpty, err)
+	}
+
+	// Should reject decode functions which return another type.
+	err := r.Register("urn:example", "x", reflect.TypeOf(""), func(el element.Element) (interface{}, error) {
+		return 1, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err = r.Decode(element.New("x").AddAttr("xmlns", "urn:example")); err == nil {
+		t.Error("Should reject decode functions which return another type.")
+	}
+	r = testRegistry(t)
+
+	// Should return the namespaces of the payloads as features.
+	want := []string{"http://jabber.org/protocol/chatstates", "urn:xmpp:receipts"}
+	if got := r.Features(); !reflect.DeepEqual(want, got) {
+		t.Error("Should return the namespaces of the payloads as features.")
+		t.Errorf("\nWant:%v\nGot :%v", want, got)
+	}
+}
+
+func TestPayload(t *testing.T) {
+	t.Parallel()
+
+	r := testRegistry(t)
+	unknown := element.New("x").AddAttr("xmlns", "urn:example").SetText("keep me")
+	msg := NewStanza(jid.New("romeo@example.net"), jid.New("juliet@example.com"), "1", "chat").
+		SetTag("message").
+		AddChild(unknown).
+		// A decoded element has its namespace as its space.
+		AddChild(element.Element{Space: "http://jabber.org/protocol/chatstates", Tag: "active"})
+
+	// Should decode a registered payload.
+	var state chatState
+	err := msg.Payload(r, &state)
+	if err != nil || state.State != "active" {
+		t.Error("Should decode a registered payload.")
+		t.Errorf("\nWant:%v\nGot :%v, %v", chatState{State: "active"}, state, err)
+	}
+
+	// Should return ErrPayloadNotFound if the stanza has no such child.
+	var rcpt receipt
+	if err = msg.Payload(r, &rcpt); err != ErrPayloadNotFound {
+		t.Errorf("\nWant:%s\nGot :%v", ErrPayloadNotFound, err)
+	}
+
+	// Should need a pointer to store the payload in.
+	if err = msg.Payload(r, state); err == nil {
+		t.Error("Should need a pointer to store the payload in.")
+	}
+
+	// Should return ErrUnknownPayload for types which are not registered.
+	var str string
+	if err = msg.Payload(r, &str); err != ErrUnknownPayload {
+		t.Errorf("\nWant:%s\nGot :%v", ErrUnknownPayload, err)
+	}
+
+	// Should encode payloads with encoding/xml and decode them again.
+	msg, err = msg.SetPayload(r, receipt{ID: "abc"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	err = msg.Payload(r, &rcpt)
+	if err != nil || rcpt.ID != "abc" {
+		t.Error("Should encode payloads with encoding/xml and decode them again.")
+		t.Errorf("\nWant:%v\nGot :%v, %v", "abc", rcpt.ID, err)
+	}
+
+	// Should replace a payload with the same name.
+	msg, err = msg.SetPayload(r, receipt{ID: "def"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(msg.Children) != 3 {
+		t.Errorf("Should replace a payload with the same name, got %d children", len(msg.Children))
+	}
+
+	// Should preserve unknown payloads.
+	if !element.Equal(unknown, msg.Children[0]) {
+		t.Error("Should preserve unknown payloads.")
+		t.Errorf("\nWant:%s\nGot :%s", unknown, msg.Children[0])
+	}
+	if _, err = r.Decode(unknown); err != ErrUnknownPayload {
+		t.Errorf("\nWant:%s\nGot :%v", ErrUnknownPayload, err)
+	}
+}
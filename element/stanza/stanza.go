@@ -2,6 +2,7 @@ package stanza
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/skriptble/nine/element"
 	"github.com/skriptble/nine/jid"
@@ -39,7 +40,9 @@ func NewStanza(to, from jid.JID, id, sType string) Stanza {
 }
 
 func (s Stanza) AddChild(el element.Element) Stanza {
-	s.Children = append(s.Children, el)
+	children := make([]element.Element, len(s.Children), len(s.Children)+1)
+	copy(children, s.Children)
+	s.Children = append(children, el)
 	return s
 }
 
@@ -88,7 +91,14 @@ func (s Stanza) TransformElement() element.Element {
 		attrs = append(attrs, element.Attr{Key: "lang", Space: "xml", Value: s.Lang})
 	}
 	if s.Namespaces != nil {
-		for alias, ns := range s.Namespaces {
+		// Sort the aliases so the output is the same every time.
+		aliases := make([]string, 0, len(s.Namespaces))
+		for alias := range s.Namespaces {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			ns := s.Namespaces[alias]
 			// Handle top level namespace
 			if alias == "" {
 				attrs = append(attrs, element.Attr{Key: "xmlns", Value: ns})
@@ -0,0 +1,129 @@
+package element
+
+import (
+	"io"
+	"sync"
+)
+
+// bufPool holds the buffers used to serialize elements. Buffers that grew
+// larger than maxPooledBuffer are not returned to the pool, so one large
+// element does not pin memory forever.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
+
+const maxPooledBuffer = 64 << 10
+
+// WriteTo implements io.WriterTo. The element is serialized into a pooled
+// buffer and written to w with a single call to Write.
+func (e Element) WriteTo(w io.Writer) (n int64, err error) {
+	bp := bufPool.Get().(*[]byte)
+	b := e.AppendTo((*bp)[:0])
+	written, err := w.Write(b)
+	if cap(b) <= maxPooledBuffer {
+		*bp = b
+		bufPool.Put(bp)
+	}
+	return int64(written), err
+}
+
+// WriteBytes serializes the Element into a slice of bytes.
+func (e Element) WriteBytes() []byte {
+	return e.AppendTo(nil)
+}
+
+// String implements the fmt.Stringer interface.
+func (e Element) String() string {
+	return string(e.AppendTo(nil))
+}
+
+// AppendTo appends the serialized element to dst and returns the extended
+// slice. Transports that keep their own buffer can use it to serialize
+// without any allocations once the buffer is large enough.
+func (e Element) AppendTo(dst []byte) []byte {
+	return e.appendTo(dst)
+}
+
+func (e Element) appendTo(dst []byte) []byte {
+	dst = append(dst, '<')
+	dst = e.appendName(dst)
+	for _, a := range e.Attr {
+		dst = append(dst, ' ')
+		dst = a.appendTo(dst)
+	}
+	if len(e.Child) == 0 {
+		return append(dst, '/', '>')
+	}
+	dst = append(dst, '>')
+	for _, c := range e.Child {
+		dst = c.appendTo(dst)
+	}
+	dst = append(dst, '<', '/')
+	dst = e.appendName(dst)
+	return append(dst, '>')
+}
+
+func (e Element) appendName(dst []byte) []byte {
+	if e.Space != "" {
+		dst = append(dst, e.Space...)
+		dst = append(dst, ':')
+	}
+	return append(dst, e.Tag...)
+}
+
+func (a Attr) appendTo(dst []byte) []byte {
+	if a.Space != "" {
+		dst = append(dst, a.Space...)
+		dst = append(dst, ':')
+	}
+	dst = append(dst, a.Key...)
+	dst = append(dst, '=', '\'')
+	dst = AppendEscaped(dst, a.Value)
+	return append(dst, '\'')
+}
+
+func (c CharData) appendTo(dst []byte) []byte {
+	return AppendEscaped(dst, c.Data)
+}
+
+// AppendEscaped appends s to dst with the XML special characters replaced by
+// entities. Runs of characters that need no escaping are copied at once.
+func AppendEscaped(dst []byte, s string) []byte {
+	last := 0
+	for i := 0; i < len(s); i++ {
+		var esc string
+		switch s[i] {
+		case '<':
+			esc = "&lt;"
+		case '>':
+			esc = "&gt;"
+		case '&':
+			esc = "&amp;"
+		case '\'':
+			esc = "&apos;"
+		case '"':
+			esc = "&quot;"
+		default:
+			continue
+		}
+		dst = append(dst, s[last:i]...)
+		dst = append(dst, esc...)
+		last = i + 1
+	}
+	return append(dst, s[last:]...)
+}
+
+// escape returns s with the XML special characters replaced by entities. It
+// only allocates if s contains characters that need escaping.
+func escape(s string) string {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '<', '>', '&', '\'', '"':
+			return string(AppendEscaped(make([]byte, 0, len(s)+8), s))
+		}
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package element
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestAppendEscaped(t *testing.T) {
+	t.Parallel()
+
+	// Should escape the XML special characters.
+	want := "a&lt;b&gt;c&amp;d&apos;e&quot;f"
+	if got := string(AppendEscaped(nil, `a<b>c&d'e"f`)); got != want {
+		t.Error("Should escape the XML special characters.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+}
+
+// AllocsPerRun cannot be used in parallel tests.
+func TestEscapeAllocs(t *testing.T) {
+	// Should not allocate if there is nothing to escape.
+	s := "nothing to escape"
+	if allocs := testing.AllocsPerRun(10, func() { escape(s) }); allocs != 0 {
+		t.Errorf("\nWant:%d allocations\nGot :%v", 0, allocs)
+	}
+}
+
+var benchMessage = New("message").
+	AddAttr("xmlns", "jabber:client").
+	AddAttr("from", "juliet@example.com/balcony").
+	AddAttr("to", "romeo@example.net").
+	AddAttr("type", "chat").
+	AddAttr("id", "ktx72v49").
+	AddChild(New("body").SetText("Art thou not Romeo, and a Montague?")).
+	AddChild(New("thread").SetText("e0ffe42b28561960c6b12b944a092794b9683a38")).
+	AddChild(New("active").AddAttr("xmlns", "http://jabber.org/protocol/chatstates"))
+
+func BenchmarkWriteTo(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchMessage.WriteTo(ioutil.Discard)
+	}
+}
+
+func BenchmarkAppendTo(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]byte, 0, 1024)
+	for i := 0; i < b.N; i++ {
+		buf = benchMessage.AppendTo(buf[:0])
+	}
+}
+
+func BenchmarkWriteBytes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchMessage.WriteBytes()
+	}
+}
+
+func BenchmarkEscape(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]byte, 0, 256)
+	for i := 0; i < b.N; i++ {
+		buf = AppendEscaped(buf[:0], `Romeo & Juliet said "<3" to the 'nurse'`)
+	}
+}
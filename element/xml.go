@@ -0,0 +1,191 @@
+package element
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+
+	"github.com/skriptble/nine/namespace"
+)
+
+// xmlURL is the namespace bound to the xml prefix.
+const xmlURL = "http://www.w3.org/XML/1998/namespace"
+
+// MarshalXML implements the xml.Marshaler interface. The element is written
+// with its own tag, the name in start is ignored. Namespace prefixes are
+// resolved through the xmlns attributes of the element and the Namespaces
+// map, so the output is in the same namespaces as the element.
+func (e Element) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	if e.Tag == "" {
+		return nil
+	}
+	return e.marshal(enc, nil)
+}
+
+func (e Element) marshal(enc *xml.Encoder, parent map[string]string) error {
+	ns := scope(parent, e)
+	start := xml.StartElement{Name: xml.Name{Local: e.Tag}}
+	switch {
+	case e.Space == "":
+		if uri := ns[""]; uri != parent[""] || parent == nil {
+			start.Name.Space = uri
+		}
+	case strings.ContainsAny(e.Space, ":/"):
+		// Elements decoded by a transport have the URI as their space.
+		start.Name.Space = e.Space
+	default:
+		start.Name.Space = resolvePrefix(e.Space, ns)
+	}
+	for _, a := range e.Attr {
+		if (a.Space == "" && a.Key == "xmlns") || a.Space == "xmlns" {
+			// The encoder declares the namespaces it needs.
+			continue
+		}
+		name := xml.Name{Local: a.Key}
+		switch {
+		case a.Space == "xml":
+			name.Space = xmlURL
+		case a.Space != "" && !strings.ContainsAny(a.Space, ":/"):
+			name.Space = resolvePrefix(a.Space, ns)
+		default:
+			name.Space = a.Space
+		}
+		start.Attr = append(start.Attr, xml.Attr{Name: name, Value: a.Value})
+	}
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, c := range e.Child {
+		var err error
+		switch t := c.(type) {
+		case Element:
+			err = t.marshal(enc, ns)
+		case CharData:
+			err = enc.EncodeToken(xml.CharData(t.Data))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// resolvePrefix returns the namespace bound to prefix. The stream prefix is
+// bound to the stream namespace when it has not been declared, since stream
+// elements are usually written without the stream header that declares it.
+func resolvePrefix(prefix string, ns map[string]string) string {
+	if uri, ok := ns[prefix]; ok {
+		return uri
+	}
+	if prefix == "stream" {
+		return namespace.Stream
+	}
+	return prefix
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface. Unlike elements
+// decoded by a transport, the namespaces of the element are kept as xmlns
+// attributes and prefixes, the same way elements created with New are built,
+// so the element can be written as is.
+func (e *Element) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	el, err := unmarshal(d, start, nil)
+	if err != nil {
+		return err
+	}
+	*e = el
+	return nil
+}
+
+func unmarshal(d *xml.Decoder, start xml.StartElement, parent map[string]string) (Element, error) {
+	el := Element{Tag: start.Name.Local, Namespaces: make(map[string]string)}
+	ns := make(map[string]string, len(parent))
+	for k, v := range parent {
+		ns[k] = v
+	}
+	for _, a := range start.Attr {
+		switch {
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			ns[""] = a.Value
+			el.Namespaces[""] = a.Value
+		case a.Name.Space == "xmlns":
+			ns[a.Name.Local] = a.Value
+			el.Namespaces[a.Name.Local] = a.Value
+		}
+	}
+	el.Space = prefixFor(start.Name.Space, ns, true)
+	for _, a := range start.Attr {
+		attr := Attr{Space: a.Name.Space, Key: a.Name.Local, Value: a.Value}
+		if a.Name.Space != "xmlns" {
+			attr.Space = prefixFor(a.Name.Space, ns, false)
+		}
+		el.Attr = append(el.Attr, attr)
+	}
+	if start.Name.Space != "" && el.Space == "" && ns[""] != start.Name.Space {
+		// The namespace was declared with a prefix we no longer know.
+		// Declare it as the default namespace instead.
+		ns[""] = start.Name.Space
+		el.Namespaces[""] = start.Name.Space
+		el.Attr = append(el.Attr, Attr{Key: "xmlns", Value: start.Name.Space})
+	}
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return Element{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := unmarshal(d, t, ns)
+			if err != nil {
+				return Element{}, err
+			}
+			el.Child = append(el.Child, child)
+		case xml.CharData:
+			el.Child = append(el.Child, CharData{Data: string(t)})
+		case xml.EndElement:
+			return el, nil
+		}
+	}
+}
+
+// prefixFor returns the prefix bound to uri. The default namespace is only
+// used for elements, attributes without a prefix are in no namespace.
+func prefixFor(uri string, ns map[string]string, element bool) string {
+	switch {
+	case uri == "":
+		return ""
+	case uri == xmlURL || uri == "xml":
+		return "xml"
+	case element && ns[""] == uri:
+		return ""
+	}
+	for prefix, v := range ns {
+		if prefix != "" && v == uri {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// Decode decodes the element into v using the encoding/xml rules, so v can be
+// a struct with xml tags. The namespaces of the element are preserved.
+func Decode(e Element, v interface{}) error {
+	var buf bytes.Buffer
+	if err := xml.NewEncoder(&buf).Encode(e); err != nil {
+		return err
+	}
+	return xml.Unmarshal(buf.Bytes(), v)
+}
+
+// Encode encodes v using the encoding/xml rules and returns the result as an
+// Element. The namespaces in the xml tags of v are preserved.
+func Encode(v interface{}) (Element, error) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return Element{}, err
+	}
+	var el Element
+	err = xml.Unmarshal(b, &el)
+	return el, err
+}
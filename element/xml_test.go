@@ -0,0 +1,86 @@
+package element
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/skriptble/nine/namespace"
+)
+
+func TestElementXML(t *testing.T) {
+	t.Parallel()
+
+	// Should marshal with encoding/xml in the same namespaces.
+	el := Bind.AddChild(JID.SetText("juliet@example.com/balcony"))
+	b, err := xml.Marshal(el)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	want := `<bind xmlns="urn:ietf:params:xml:ns:xmpp-bind"><jid>juliet@example.com/balcony</jid></bind>`
+	if string(b) != want {
+		t.Error("Should marshal with encoding/xml in the same namespaces.")
+		t.Errorf("\nWant:%s\nGot :%s", want, b)
+	}
+
+	// Should unmarshal into an element that can be written as is.
+	var got Element
+	in := `<stream:error xmlns:stream="http://etherx.jabber.org/streams">` +
+		`<conflict xmlns="urn:ietf:params:xml:ns:xmpp-streams"/>` +
+		`<text xmlns="urn:ietf:params:xml:ns:xmpp-streams" xml:lang="en">Replaced</text></stream:error>`
+	if err = xml.Unmarshal([]byte(in), &got); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	want = `<stream:error xmlns:stream='http://etherx.jabber.org/streams'>` +
+		`<conflict xmlns='urn:ietf:params:xml:ns:xmpp-streams'/>` +
+		`<text xmlns='urn:ietf:params:xml:ns:xmpp-streams' xml:lang='en'>Replaced</text></stream:error>`
+	if got.String() != want {
+		t.Error("Should unmarshal into an element that can be written as is.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+	if !got.MatchNamespace(namespace.Stream) || !got.SelectElement("conflict").MatchNamespace("urn:ietf:params:xml:ns:xmpp-streams") {
+		t.Error("Should keep the namespaces of unmarshaled elements.")
+	}
+}
+
+func TestDecodeEncode(t *testing.T) {
+	t.Parallel()
+
+	type item struct {
+		JID  string `xml:"jid,attr"`
+		Name string `xml:"name,attr,omitempty"`
+	}
+	type query struct {
+		XMLName xml.Name `xml:"jabber:iq:roster query"`
+		Ver     string   `xml:"ver,attr,omitempty"`
+		Items   []item   `xml:"item"`
+	}
+
+	// Should encode a struct into an element.
+	el, err := Encode(query{Ver: "ver7", Items: []item{{JID: "nurse@example.com", Name: "Nurse"}}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !el.MatchNamespace("jabber:iq:roster") || el.SelectElement("item").SelectAttrValue("name", "") != "Nurse" {
+		t.Error("Should encode a struct into an element.")
+		t.Errorf("\nGot :%s", el)
+	}
+
+	// Should decode an element into a struct.
+	el = New("query").AddAttr("xmlns", "jabber:iq:roster").
+		AddChild(New("item").AddAttr("jid", "romeo@example.net")).
+		AddChild(New("item").AddAttr("jid", "mercutio@example.com"))
+	var q query
+	if err = Decode(el, &q); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(q.Items) != 2 || q.Items[1].JID != "mercutio@example.com" {
+		t.Error("Should decode an element into a struct.")
+		t.Errorf("\nGot :%+v", q)
+	}
+
+	// Should not decode an element in another namespace.
+	el = New("query").AddAttr("xmlns", "jabber:iq:private")
+	if err = Decode(el, &q); err == nil {
+		t.Error("Should not decode an element in another namespace.")
+	}
+}
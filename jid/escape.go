@@ -0,0 +1,99 @@
+package jid
+
+import "strings"
+
+// escapes maps the characters escaped by XEP-0106 to their escape sequences.
+var escapes = map[byte]string{
+	' ':  `\20`,
+	'"':  `\22`,
+	'&':  `\26`,
+	'\'': `\27`,
+	'/':  `\2f`,
+	':':  `\3a`,
+	'<':  `\3c`,
+	'>':  `\3e`,
+	'@':  `\40`,
+	'\\': `\5c`,
+}
+
+// unescapes maps the XEP-0106 escape sequences to the characters they
+// represent.
+var unescapes = map[string]byte{
+	`20`: ' ',
+	`22`: '"',
+	`26`: '&',
+	`27`: '\'',
+	`2f`: '/',
+	`3a`: ':',
+	`3c`: '<',
+	`3e`: '>',
+	`40`: '@',
+	`5c`: '\\',
+}
+
+// Escape escapes a localpart as described in XEP-0106 so that characters
+// such as @, spaces and quotes can be used in a JID. A backslash is only
+// escaped when it is followed by an escape sequence.
+func Escape(local string) string {
+	var b strings.Builder
+	for i := 0; i < len(local); i++ {
+		c := local[i]
+		esc, ok := escapes[c]
+		if c == '\\' {
+			ok = isEscape(local[i:])
+		}
+		if !ok {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString(esc)
+	}
+	return b.String()
+}
+
+// Unescape reverses Escape. Backslashes that do not start an escape sequence
+// are left as they are.
+func Unescape(local string) string {
+	var b strings.Builder
+	for i := 0; i < len(local); i++ {
+		if local[i] == '\\' && isEscape(local[i:]) {
+			b.WriteByte(unescapes[strings.ToLower(local[i+1:i+3])])
+			i += 2
+			continue
+		}
+		b.WriteByte(local[i])
+	}
+	return b.String()
+}
+
+// isEscape reports whether s starts with an escape sequence.
+func isEscape(s string) bool {
+	if len(s) < 3 || s[0] != '\\' {
+		return false
+	}
+	_, ok := unescapes[strings.ToLower(s[1:3])]
+	return ok
+}
+
+// FromUnescaped creates a JID from a localpart that has not been escaped, such
+// as an identifier from a foreign system. The localpart is escaped and the
+// JID parsed. The localpart cannot start or end with a space.
+func FromUnescaped(local, domain, resource string) (JID, error) {
+	if strings.HasPrefix(local, " ") || strings.HasSuffix(local, " ") {
+		return Empty, &Error{Part: LocalPart, Err: ErrInvalidPart}
+	}
+	str := domain
+	if local != "" {
+		str = Escape(local) + "@" + str
+	}
+	if resource != "" {
+		str += "/" + resource
+	}
+	return Parse(str)
+}
+
+// UnescapedLocal returns the localpart of the JID with XEP-0106 escape
+// sequences reversed.
+func (j JID) UnescapedLocal() string {
+	return Unescape(j.local)
+}
@@ -0,0 +1,56 @@
+package jid
+
+import "testing"
+
+func TestEscape(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct{ unescaped, escaped string }{
+		{"space cadet", `space\20cadet`},
+		{`call me "ishmael"`, `call\20me\20\22ishmael\22`},
+		{"at&t guy", `at\26t\20guy`},
+		{"d'artagnan", `d\27artagnan`},
+		{"/.fanboy", `\2f.fanboy`},
+		{"::foo::", `\3a\3afoo\3a\3a`},
+		{"<foo>", `\3cfoo\3e`},
+		{"user@host", `user\40host`},
+		{`c:\net`, `c\3a\net`},
+		{`c:\\net`, `c\3a\\net`},
+		{`c:\cool stuff`, `c\3a\cool\20stuff`},
+		{`c:\5commas`, `c\3a\5c5commas`},
+	}
+	for _, test := range tests {
+		// Should escape the localpart.
+		if got := Escape(test.unescaped); got != test.escaped {
+			t.Error("Should escape the localpart.")
+			t.Errorf("\nWant:%s\nGot :%s", test.escaped, got)
+		}
+		// Should unescape the localpart.
+		if got := Unescape(test.escaped); got != test.unescaped {
+			t.Error("Should unescape the localpart.")
+			t.Errorf("\nWant:%s\nGot :%s", test.unescaped, got)
+		}
+	}
+}
+
+func TestFromUnescaped(t *testing.T) {
+	t.Parallel()
+
+	// Should round-trip a foreign identifier.
+	j, err := FromUnescaped("juliet.capulet@example.org", "gateway.example.com", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	want := `juliet.capulet\40example.org@gateway.example.com`
+	if j.String() != want {
+		t.Errorf("\nWant:%s\nGot :%s", want, j)
+	}
+	if got := j.UnescapedLocal(); got != "juliet.capulet@example.org" {
+		t.Errorf("\nWant:%s\nGot :%s", "juliet.capulet@example.org", got)
+	}
+
+	// Should reject localparts starting or ending with a space.
+	if _, err = FromUnescaped(" juliet", "example.com", ""); err == nil {
+		t.Error("Should reject localparts starting or ending with a space.")
+	}
+}
@@ -1,93 +1,174 @@
 package jid
 
 import (
-	"bytes"
+	"encoding/xml"
+	"errors"
 	"net"
 	"strings"
 
 	"golang.org/x/net/idna"
-	"golang.org/x/text/unicode/norm"
-	"golang.org/x/text/width"
+	"golang.org/x/text/secure/precis"
 )
 
 var Empty = JID{}
 
-// TODO: Implement XEP-0106 for escaping
-var jidReplacer = strings.NewReplacer(
-	`"`, "",
-	"&", "",
-	"'", "",
-	"/", "",
-	":", "",
-	"<", "",
-	">", "",
-	"@", "",
+// The errors returned by Parse. They are wrapped in an *Error which records
+// the part of the JID that is malformed. All of them should be reported to
+// the sender as jid-malformed.
+var (
+	ErrEmptyPart   = errors.New("part is empty")
+	ErrPartTooLong = errors.New("part is longer than 1023 bytes")
+	ErrInvalidPart = errors.New("part contains disallowed characters")
 )
 
+// Part identifies the part of a JID.
+type Part string
+
+// The parts of a JID.
+const (
+	LocalPart    Part = "localpart"
+	DomainPart   Part = "domainpart"
+	ResourcePart Part = "resourcepart"
+)
+
+// maxPartLength is the maximum length of each part of a JID in bytes.
+const maxPartLength = 1023
+
+// Error is the error returned by Parse when a JID is malformed.
+type Error struct {
+	Part Part
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return "jid: " + string(e.Part) + " " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// localExcluded are the characters RFC 7622 disallows in a localpart in
+// addition to those disallowed by the UsernameCaseMapped profile.
+const localExcluded = `"&'/:<>@`
+
 type JID struct {
 	local    string
 	domain   string
 	resource string
 }
 
+// New parses str as a JID. It returns Empty if the JID is malformed. Use Parse
+// to find out why.
 func New(str string) JID {
-	// TODO(skriptble): Implement RFC7622
+	j, err := Parse(str)
+	if err != nil {
+		return Empty
+	}
+	return j
+}
+
+// Parse parses and enforces a JID as described in RFC 7622. Localparts are
+// enforced with the UsernameCaseMapped profile, resourceparts with the
+// OpaqueString profile and domainparts with IDNA2008. An empty string parses
+// to Empty.
+func Parse(str string) (JID, error) {
+	if str == "" {
+		return Empty, nil
+	}
 	var local, domain, resource string
+	var hasLocal, hasResource bool
 
 	domain = str
 	slash := strings.IndexByte(domain, '/')
 	if slash != -1 {
-		resource = domain[slash+1:]
+		resource, hasResource = domain[slash+1:], true
 		domain = domain[:slash]
 	}
 
 	at := strings.IndexByte(domain, '@')
 	if at != -1 {
-		local = domain[:at]
+		local, hasLocal = domain[:at], true
 		domain = domain[at+1:]
 	}
 
-	local = parseLocal(local)
-	domain = parseDomain(domain)
-	resource = parseResource(resource)
-
-	if len([]byte(local)) > 1024 || len([]byte(domain)) > 1024 || len([]byte(resource)) > 1024 {
-		return Empty
+	var j JID
+	var err error
+	if hasLocal {
+		if j.local, err = parseLocal(local); err != nil {
+			return Empty, &Error{Part: LocalPart, Err: err}
+		}
 	}
-
-	return JID{
-		local:    local,
-		domain:   domain,
-		resource: resource,
+	if j.domain, err = parseDomain(domain); err != nil {
+		return Empty, &Error{Part: DomainPart, Err: err}
 	}
+	if hasResource {
+		if j.resource, err = parseResource(resource); err != nil {
+			return Empty, &Error{Part: ResourcePart, Err: err}
+		}
+	}
+	return j, nil
 }
 
-// parseDomain parses the domain part of a jid according to RFC7622
-func parseDomain(domain string) string {
-	ip := net.ParseIP(domain)
-	if ip != nil {
-		return ip.String()
+// parseDomain parses the domain part of a jid according to RFC7622. IP
+// literals are kept as IP addresses, with IPv6 addresses enclosed in brackets.
+func parseDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" {
+		return "", ErrEmptyPart
+	}
+	if strings.HasPrefix(domain, "[") && strings.HasSuffix(domain, "]") {
+		ip := net.ParseIP(domain[1 : len(domain)-1])
+		if ip == nil || ip.To4() != nil {
+			return "", ErrInvalidPart
+		}
+		return "[" + ip.String() + "]", nil
+	}
+	if ip := net.ParseIP(domain); ip != nil {
+		if ip.To4() == nil {
+			// IPv6 literals must be enclosed in brackets.
+			return "", ErrInvalidPart
+		}
+		return ip.String(), nil
 	}
 
-	domain, err := idna.ToUnicode(domain)
+	domain, err := idna.Lookup.ToUnicode(domain)
 	if err != nil {
-		return ""
+		return "", ErrInvalidPart
 	}
-	return domain
+	return checkLength(domain)
 }
 
 // parseLocal parses the local part of a jid according to RFC7622
-func parseLocal(local string) string {
-	local = width.Fold.String(local)
-	b := bytes.ToLower([]byte(local))
-	local = string(b)
-	local = norm.NFC.String(local)
-	return jidReplacer.Replace(local)
+func parseLocal(local string) (string, error) {
+	if local == "" {
+		return "", ErrEmptyPart
+	}
+	local, err := precis.UsernameCaseMapped.String(local)
+	if err != nil || strings.ContainsAny(local, localExcluded) {
+		return "", ErrInvalidPart
+	}
+	return checkLength(local)
 }
 
 // parseResource parses the resource part of a jid according to RFC7622
-func parseResource(resource string) string {
-	return norm.NFC.String(resource)
+func parseResource(resource string) (string, error) {
+	if resource == "" {
+		return "", ErrEmptyPart
+	}
+	resource, err := precis.OpaqueString.String(resource)
+	if err != nil {
+		return "", ErrInvalidPart
+	}
+	return checkLength(resource)
+}
+
+func checkLength(part string) (string, error) {
+	if len(part) > maxPartLength {
+		return "", ErrPartTooLong
+	}
+	return part, nil
 }
 
 func (j JID) Local() string {
@@ -98,8 +179,10 @@ func (j JID) Domain() string {
 	return j.domain
 }
 
+// SetDomain returns a copy of the JID with its domain replaced. The domain is
+// set to empty if it is malformed.
 func (j JID) SetDomain(domain string) JID {
-	j.domain = parseDomain(domain)
+	j.domain, _ = parseDomain(domain)
 	return j
 }
 
@@ -119,3 +202,75 @@ func (j JID) String() string {
 
 	return res
 }
+
+// WithResource returns a copy of the JID with its resource replaced. The
+// resource is removed if resource is empty or malformed.
+func (j JID) WithResource(resource string) JID {
+	j.resource, _ = parseResource(resource)
+	return j
+}
+
+// WithLocal returns a copy of the JID with its localpart replaced. The
+// localpart is removed if local is empty or malformed.
+func (j JID) WithLocal(local string) JID {
+	j.local, _ = parseLocal(local)
+	return j
+}
+
+// Bare returns the JID without its resource.
+func (j JID) Bare() JID {
+	j.resource = ""
+	return j
+}
+
+// IsBare reports whether the JID has no resource.
+func (j JID) IsBare() bool {
+	return j.domain != "" && j.resource == ""
+}
+
+// IsFull reports whether the JID has a resource.
+func (j JID) IsFull() bool {
+	return j.domain != "" && j.resource != ""
+}
+
+// IsDomain reports whether the JID has only a domain, such as the JID of a
+// server or component.
+func (j JID) IsDomain() bool {
+	return j.domain != "" && j.local == "" && j.resource == ""
+}
+
+// Equal reports whether j and other are the same JID. Both are expected to
+// have been created by Parse or New, so their parts are already enforced.
+func (j JID) Equal(other JID) bool {
+	return j == other
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (j JID) MarshalText() ([]byte, error) {
+	return []byte(j.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface. It returns
+// an error if the text is not a valid JID.
+func (j *JID) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*j = parsed
+	return nil
+}
+
+// MarshalXMLAttr implements the xml.MarshalerAttr interface. Empty JIDs are
+// omitted.
+func (j JID) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	if j == Empty {
+		return xml.Attr{}, nil
+	}
+	return xml.Attr{Name: name, Value: j.String()}, nil
+}
+
+// UnmarshalXMLAttr implements the xml.UnmarshalerAttr interface.
+func (j *JID) UnmarshalXMLAttr(attr xml.Attr) error {
+	return j.UnmarshalText([]byte(attr.Value))
+}
@@ -0,0 +1,98 @@
+package jid
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("a", 1024)
+	tests := []struct {
+		in   string
+		want string
+		part Part
+		err  error
+	}{
+		{"juliet@example.com/foo", "juliet@example.com/foo", "", nil},
+		{"Juliet@Example.com", "juliet@example.com", "", nil},
+		{"juliet@example.com/foo bar", "juliet@example.com/foo bar", "", nil},
+		{"juliet@example.com/foo@bar", "juliet@example.com/foo@bar", "", nil},
+		{"example.com.", "example.com", "", nil},
+		{"ｊｕｌｉｅｔ@example.com", "juliet@example.com", "", nil},
+		{"juliet@xn--mnchen-3ya.de", "juliet@münchen.de", "", nil},
+		{"juliet@192.0.2.1", "juliet@192.0.2.1", "", nil},
+		{"juliet@[2001:db8::1]", "juliet@[2001:db8::1]", "", nil},
+		{"juliet@2001:db8::1", "", DomainPart, ErrInvalidPart},
+		{"@example.com", "", LocalPart, ErrEmptyPart},
+		{"example.com/", "", ResourcePart, ErrEmptyPart},
+		{"juliet@", "", DomainPart, ErrEmptyPart},
+		{`"juliet"@example.com`, "", LocalPart, ErrInvalidPart},
+		{"juliet capulet@example.com", "", LocalPart, ErrInvalidPart},
+		{"juliet@example.com/\u0007", "", ResourcePart, ErrInvalidPart},
+		{long + "@example.com", "", LocalPart, ErrPartTooLong},
+		{"juliet@example.com/" + long, "", ResourcePart, ErrPartTooLong},
+	}
+
+	for _, test := range tests {
+		j, err := Parse(test.in)
+		if test.err == nil {
+			if err != nil || j.String() != test.want {
+				t.Errorf("Should parse %q.", test.in)
+				t.Errorf("\nWant:%s\nGot :%s (%v)", test.want, j, err)
+			}
+			continue
+		}
+		var jerr *Error
+		if !errors.As(err, &jerr) || jerr.Part != test.part || !errors.Is(err, test.err) {
+			t.Errorf("Should reject %q.", test.in)
+			t.Errorf("\nWant:%s %s\nGot :%v", test.part, test.err, err)
+		}
+	}
+}
+
+func TestJIDMethods(t *testing.T) {
+	t.Parallel()
+
+	full := New("juliet@example.com/balcony")
+
+	// Should derive bare and modified JIDs.
+	if got := full.Bare(); !got.Equal(New("juliet@example.com")) || !got.IsBare() || got.IsFull() {
+		t.Errorf("\nWant:%s\nGot :%s", "juliet@example.com", got)
+	}
+	if got := full.WithLocal("Romeo"); got.String() != "romeo@example.com/balcony" {
+		t.Errorf("\nWant:%s\nGot :%s", "romeo@example.com/balcony", got)
+	}
+	if got := full.WithResource("orchard"); got.String() != "juliet@example.com/orchard" {
+		t.Errorf("\nWant:%s\nGot :%s", "juliet@example.com/orchard", got)
+	}
+	if !full.IsFull() || full.IsDomain() || !New("example.com").IsDomain() {
+		t.Error("Should distinguish full and domain JIDs.")
+	}
+
+	// Should marshal and unmarshal as text and XML attributes.
+	type payload struct {
+		XMLName xml.Name `xml:"item"`
+		JID     JID      `xml:"jid,attr"`
+		Owner   JID      `xml:"owner"`
+	}
+	b, err := xml.Marshal(payload{JID: full, Owner: full.Bare()})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	want := `<item jid="juliet@example.com/balcony"><owner>juliet@example.com</owner></item>`
+	if string(b) != want {
+		t.Errorf("\nWant:%s\nGot :%s", want, b)
+	}
+	var p payload
+	if err = xml.Unmarshal(b, &p); err != nil || !p.JID.Equal(full) || !p.Owner.Equal(full.Bare()) {
+		t.Error("Should unmarshal JIDs.")
+		t.Errorf("\nGot :%+v (%v)", p, err)
+	}
+	if err = xml.Unmarshal([]byte(`<item jid="@example.com"/>`), &p); err == nil {
+		t.Error("Should reject malformed JIDs when unmarshaling.")
+	}
+}
@@ -6,6 +6,9 @@ const (
 	SASL   = "urn:ietf:params:xml:ns:xmpp-sasl"
 	Bind   = "urn:ietf:params:xml:ns:xmpp-bind"
 	Stanza = "urn:ietf:params:xml:ns:xmpp-stanzas"
+	SASL2  = "urn:xmpp:sasl:2"
+	Bind2  = "urn:xmpp:bind:0"
+	FAST   = "urn:xmpp:fast:0"
 	// TODO: Move this to Ten
 	Session = "urn:ietf:params:xml:ns:xmpp-session"
 	Client  = "jabber:client"
@@ -0,0 +1,257 @@
+package sasl
+
+import (
+	"encoding/base64"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+// Credentials are the credentials used by the initiating entity to
+// authenticate.
+type Credentials struct {
+	// Authzid is the identity to act as. It is usually empty, in which case
+	// the identity is derived from Username.
+	Authzid  string
+	Username string
+	Password string
+}
+
+// ClientMechanism is the interface implemented by the initiating entity's
+// side of a SASL mechanism. A ClientMechanism is a factory for ClientSessions.
+type ClientMechanism interface {
+	// Name returns the name of the mechanism, for example SCRAM-SHA-256.
+	Name() string
+	// Start returns a new ClientSession for a single authentication exchange.
+	Start(props stream.Properties) ClientSession
+}
+
+// ClientSession is the interface implemented by a single authentication
+// exchange of the initiating entity's side of a SASL mechanism.
+type ClientSession interface {
+	// Next is called with nil to create the initial response and then with
+	// the decoded data of each challenge. It returns the decoded response.
+	Next(challenge []byte) (response []byte, err error)
+	// Verify is called with the decoded additional data of the success
+	// element. It returns an error if the receiving entity could not be
+	// verified.
+	Verify(data []byte) error
+}
+
+// ClientHandler handles SASL negotiation for a stream in initiating mode. It
+// is a stream.FeatureHandler for the mechanisms feature and a
+// stream.ElementHandler for the challenge, success and failure elements.
+type ClientHandler struct {
+	mechs []ClientMechanism
+}
+
+// clientState is the per-stream state of client side SASL negotiation.
+type clientState struct {
+	sess ClientSession
+}
+
+// NewClientHandler creates a new ClientHandler for the given mechanisms. When
+// several mechanisms are supported by both entities the strongest is used.
+func NewClientHandler(mechs ...ClientMechanism) *ClientHandler {
+	return &ClientHandler{mechs: mechs}
+}
+
+// HandleFeature implements the stream.FeatureHandler interface. It chooses a
+// mechanism from the mechanisms element and sends the auth element.
+func (ch *ClientHandler) HandleFeature(el element.Element, props stream.Properties) ([]element.Element, stream.Properties) {
+	if props.Status&stream.Auth != 0 {
+		return []element.Element{}, props
+	}
+	offered := make(map[string]bool)
+	for _, m := range el.ChildElements() {
+		if m.Tag == "mechanism" {
+			offered[strings.TrimSpace(m.Text())] = true
+		}
+	}
+	mech := ch.choose(offered, props)
+	if mech == nil {
+		log.Println("No mutually supported SASL mechanism")
+		props.Status = props.Status | stream.Closed
+		return []element.Element{}, props
+	}
+
+	sess := mech.Start(props)
+	resp, err := sess.Next(nil)
+	if err != nil {
+		log.Printf("Could not start SASL mechanism %s: %s", mech.Name(), err)
+		props.Status = props.Status | stream.Closed
+		return []element.Element{}, props
+	}
+	props.SASL = clientState{sess: sess}
+	auth := element.New("auth").
+		AddAttr("xmlns", namespace.SASL).
+		AddAttr("mechanism", mech.Name()).
+		SetText(encode(resp))
+	return []element.Element{auth}, props
+}
+
+// HandleElement implements the stream.ElementHandler interface. It answers
+// challenges and verifies the receiving entity on success.
+func (ch *ClientHandler) HandleElement(el element.Element, props stream.Properties) ([]element.Element, stream.Properties) {
+	st, ok := props.SASL.(clientState)
+	if !ok {
+		props.Status = props.Status | stream.Closed
+		return []element.Element{}, props
+	}
+	data, err := decode(el.Text())
+	if err != nil {
+		el = element.SASLFailure.IncorrectEncoding
+	}
+
+	switch el.Tag {
+	case "challenge":
+		var resp []byte
+		resp, err = st.sess.Next(data)
+		if err != nil {
+			log.Printf("SASL challenge could not be answered: %s", err)
+			props.SASL = nil
+			return []element.Element{element.SASL.Abort}, props
+		}
+		response := element.New("response").AddAttr("xmlns", namespace.SASL).SetText(encode(resp))
+		return []element.Element{response}, props
+	case "success":
+		props.SASL = nil
+		if err = st.sess.Verify(data); err != nil {
+			log.Printf("Could not verify the receiving entity: %s", err)
+			props.Status = props.Status | stream.Closed
+			return []element.Element{}, props
+		}
+		props.Status = props.Status | stream.Auth | stream.Restart
+	default:
+		log.Printf("SASL authentication failed: %s", el)
+		props.SASL = nil
+		props.Status = props.Status | stream.Closed
+	}
+	return []element.Element{}, props
+}
+
+// choose returns the strongest mechanism that was offered and whose
+// requirements are met by the stream.
+func (ch *ClientHandler) choose(offered map[string]bool, props stream.Properties) ClientMechanism {
+	var mechs []ClientMechanism
+	for _, mech := range ch.mechs {
+		if !offered[mech.Name()] {
+			continue
+		}
+		if rq, ok := mech.(Requirer); ok && rq.Requirements().Satisfied(props) != nil {
+			continue
+		}
+		mechs = append(mechs, mech)
+	}
+	if len(mechs) == 0 {
+		return nil
+	}
+	sort.SliceStable(mechs, func(i, j int) bool {
+		return strength(mechs[i].Name()) > strength(mechs[j].Name())
+	})
+	return mechs[0]
+}
+
+// strength ranks mechanisms by name. Certificate based authentication is
+// preferred, followed by SCRAM with channel binding, SCRAM and finally PLAIN.
+func strength(name string) int {
+	switch {
+	case name == "EXTERNAL":
+		return 100
+	case strings.HasPrefix(name, "SCRAM-"):
+		rank := 20
+		if strings.HasSuffix(name, "-PLUS") {
+			rank = 40
+			name = strings.TrimSuffix(name, "-PLUS")
+		}
+		switch strings.TrimPrefix(name, "SCRAM-") {
+		case SHA512.Name:
+			rank += 3
+		case SHA256.Name:
+			rank += 2
+		case SHA1.Name:
+			rank += 1
+		}
+		return rank
+	case name == "PLAIN":
+		return 10
+	}
+	return 0
+}
+
+// encode encodes SASL data for the text of an element. Empty data is encoded
+// as "=" as required by RFC6120.
+func encode(data []byte) string {
+	if len(data) == 0 {
+		return "="
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// decode reverses encode.
+func decode(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if text == "=" || text == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(text)
+}
+
+// plainClientMech implements the client side of the plain mechanism.
+type plainClientMech struct {
+	creds Credentials
+}
+
+// NewPlainClient creates the client side of the plain mechanism.
+func NewPlainClient(creds Credentials) ClientMechanism {
+	return plainClientMech{creds: creds}
+}
+
+// Name implements the ClientMechanism interface.
+func (pm plainClientMech) Name() string { return "PLAIN" }
+
+// Requirements implements the Requirer interface. The password is never sent
+// over an unencrypted stream.
+func (pm plainClientMech) Requirements() Requirement { return RequireTLS }
+
+// Start implements the ClientMechanism interface.
+func (pm plainClientMech) Start(_ stream.Properties) ClientSession { return pm }
+
+// Next implements the ClientSession interface.
+func (pm plainClientMech) Next(_ []byte) ([]byte, error) {
+	return []byte(pm.creds.Authzid + "\000" + pm.creds.Username + "\000" + pm.creds.Password), nil
+}
+
+// Verify implements the ClientSession interface.
+func (pm plainClientMech) Verify(_ []byte) error { return nil }
+
+// externalClientMech implements the client side of the external mechanism
+// using the TLS client certificate.
+type externalClientMech struct {
+	authzid string
+}
+
+// NewExternalClient creates the client side of the external mechanism. The
+// client certificate must be set on the TLS configuration of the transport.
+func NewExternalClient(authzid string) ClientMechanism {
+	return externalClientMech{authzid: authzid}
+}
+
+// Name implements the ClientMechanism interface.
+func (em externalClientMech) Name() string { return "EXTERNAL" }
+
+// Requirements implements the Requirer interface.
+func (em externalClientMech) Requirements() Requirement { return RequireTLS }
+
+// Start implements the ClientMechanism interface.
+func (em externalClientMech) Start(_ stream.Properties) ClientSession { return em }
+
+// Next implements the ClientSession interface.
+func (em externalClientMech) Next(_ []byte) ([]byte, error) { return []byte(em.authzid), nil }
+
+// Verify implements the ClientSession interface.
+func (em externalClientMech) Verify(_ []byte) error { return nil }
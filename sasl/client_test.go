@@ -0,0 +1,164 @@
+package sasl
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+func TestSCRAMClient(t *testing.T) {
+	t.Parallel()
+
+	var want, got string
+	var err error
+
+	// Should produce the client messages from the RFC5802 example.
+	creds := Credentials{Username: "user", Password: "pencil"}
+	sess := NewSCRAMClient(SHA1, false, creds).Start(stream.Properties{}).(*scramClient)
+	_, err = sess.Next(nil)
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	sess.nonce = "fyko+d2lbbFgONRv9qkxdawL"
+	sess.clientFirstBare = "n=user,r=" + sess.nonce
+
+	resp, err := sess.Next([]byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"))
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	want = "c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts="
+	got = string(resp)
+	if want != got {
+		t.Error("Should produce the client messages from the RFC5802 example.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+
+	// Should verify the server signature.
+	err = sess.Verify([]byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ="))
+	if err != nil {
+		t.Errorf("Should verify the server signature. Unexpected error: %s", err)
+	}
+	err = sess.Verify([]byte("v=AAAAAAAAAAAAAAAAAAAAAAAAAAA="))
+	if err != ErrInvalidServerSignature {
+		t.Error("Should reject an invalid server signature.")
+		t.Errorf("\nWant:%s\nGot :%s", ErrInvalidServerSignature, err)
+	}
+
+	// Should reject a nonce that does not start with the client nonce.
+	sess = NewSCRAMClient(SHA1, false, creds).Start(stream.Properties{}).(*scramClient)
+	sess.Next(nil)
+	_, err = sess.Next([]byte("r=foobar,s=QSXCR+Q6sek8bf92,i=4096"))
+	if err != ErrInvalidNonce {
+		t.Error("Should reject a nonce that does not start with the client nonce.")
+		t.Errorf("\nWant:%s\nGot :%s", ErrInvalidNonce, err)
+	}
+
+	// Should reject an iteration count above the maximum.
+	sess = NewSCRAMClient(SHA1, false, creds).Start(stream.Properties{}).(*scramClient)
+	sess.Next(nil)
+	_, err = sess.Next([]byte("r=" + sess.nonce + "abc,s=QSXCR+Q6sek8bf92,i=1000000000"))
+	if err != ErrTooManyIterations {
+		t.Error("Should reject an iteration count above the maximum.")
+		t.Errorf("\nWant:%s\nGot :%v", ErrTooManyIterations, err)
+	}
+}
+
+func TestClientHandlerChoose(t *testing.T) {
+	t.Parallel()
+
+	var props stream.Properties
+	creds := Credentials{Username: "user", Password: "pencil"}
+	ch := NewClientHandler(
+		NewPlainClient(creds),
+		NewSCRAMClient(SHA1, false, creds),
+		NewSCRAMClient(SHA256, false, creds),
+		NewSCRAMClient(SHA256, true, creds),
+	)
+	mechs := element.SASLMechanisms.
+		AddChild(element.New("mechanism").SetText("PLAIN")).
+		AddChild(element.New("mechanism").SetText("SCRAM-SHA-1")).
+		AddChild(element.New("mechanism").SetText("SCRAM-SHA-256")).
+		AddChild(element.New("mechanism").SetText("SCRAM-SHA-256-PLUS"))
+
+	// Should choose the strongest mechanism supported by the stream.
+	got, props := ch.HandleFeature(mechs, props)
+	if len(got) != 1 || got[0].SelectAttrValue("mechanism", "") != "SCRAM-SHA-256" {
+		t.Error("Should choose the strongest mechanism supported by the stream.")
+		t.Errorf("\nGot :%s", got)
+	}
+	if props.SASL == nil {
+		t.Error("Should store the client session on the properties.")
+	}
+
+	// Should prefer channel binding when it is available.
+	props = stream.Properties{
+		Status: stream.Secure,
+		TLS:    &tls.ConnectionState{Version: tls.VersionTLS12, TLSUnique: []byte("unique")},
+	}
+	got, _ = ch.HandleFeature(mechs, props)
+	if len(got) != 1 || got[0].SelectAttrValue("mechanism", "") != "SCRAM-SHA-256-PLUS" {
+		t.Error("Should prefer channel binding when it is available.")
+		t.Errorf("\nGot :%s", got)
+	}
+
+	// Should set Auth and Restart on success.
+	props = stream.Properties{Status: stream.Secure, TLS: &tls.ConnectionState{}}
+	plainOnly := element.SASLMechanisms.AddChild(element.New("mechanism").SetText("PLAIN"))
+	_, props = ch.HandleFeature(plainOnly, props)
+	_, props = ch.HandleElement(element.New("success").AddAttr("xmlns", namespace.SASL), props)
+	if props.Status&stream.Auth == 0 || props.Status&stream.Restart == 0 {
+		t.Error("Should set Auth and Restart on success.")
+	}
+}
+
+func TestClientHandlerStrippedPlus(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryCredentialStore()
+	a, _ := NewAccount("juliet", "secret", CredentialParams{Iterations: 1024, SaltSize: 16, Hashes: []Hash{SHA256}})
+	store.PutAccount(a)
+	h := NewHandler(map[string]Mechanism{
+		SCRAMName(SHA256, false): NewSCRAMMechanism(SHA256, false, store),
+		SCRAMName(SHA256, true):  NewSCRAMMechanism(SHA256, true, store),
+	})
+	ch := NewClientHandler(
+		NewSCRAMClient(SHA256, false, Credentials{Username: "juliet", Password: "secret"}),
+		NewSCRAMClient(SHA256, true, Credentials{Username: "juliet", Password: "secret"}),
+	)
+	props := stream.Properties{
+		Domain: "localhost",
+		Status: stream.Secure,
+		TLS:    &tls.ConnectionState{Version: tls.VersionTLS12, TLSUnique: []byte("unique")},
+	}
+	stripped := element.SASLMechanisms.
+		AddChild(element.New("mechanism").SetText(SCRAMName(SHA256, false)))
+
+	// Should send the y flag when channel binding is supported but not offered.
+	auth, _ := ch.HandleFeature(stripped, props)
+	if len(auth) != 1 {
+		t.Fatalf("Should send an auth element.\nGot :%s", auth)
+	}
+	data, _ := decode(auth[0].Text())
+	if want, got := "y,,", string(data[:3]); want != got {
+		t.Error("Should send the y flag when channel binding is supported but not offered.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+
+	// Should be rejected by a receiving entity that offered channel binding.
+	elems, _ := h.HandleElement(auth[0], props)
+	if len(elems) != 1 || elems[0].SelectElement("not-authorized").Tag == "" {
+		t.Error("Should be rejected by a receiving entity that offered channel binding.")
+		t.Errorf("\nGot :%s", elems)
+	}
+
+	// Should send the n flag without channel binding data.
+	auth, _ = ch.HandleFeature(stripped, stream.Properties{Domain: "localhost"})
+	data, _ = decode(auth[0].Text())
+	if want, got := "n,,", string(data[:3]); want != got {
+		t.Error("Should send the n flag without channel binding data.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+}
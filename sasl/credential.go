@@ -0,0 +1,250 @@
+package sasl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+	"sync"
+
+	"github.com/skriptble/nine/jid"
+)
+
+// ErrAccountNotFound is returned by a CredentialStore when an account does not
+// exist.
+var ErrAccountNotFound = errors.New("sasl: account not found")
+
+// SCRAMCredential holds the keys derived from a password for one SCRAM hash.
+// The password itself cannot be recovered from them.
+type SCRAMCredential struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// PlainCredential is a salted PBKDF2-SHA-256 hash of a password used to
+// verify passwords sent with the plain mechanism.
+type PlainCredential struct {
+	Salt       []byte
+	Iterations int
+	Hash       []byte
+}
+
+// Account holds the stored credentials of an account.
+type Account struct {
+	Username string
+	Disabled bool
+	// SCRAM maps the name of a hash, for example SHA-256, to the keys
+	// derived for it.
+	SCRAM map[string]SCRAMCredential
+	// Plain is an optional hash for verifying PLAIN passwords. When it is
+	// not set, passwords are verified with the SCRAM keys.
+	Plain *PlainCredential
+}
+
+// CredentialStore is the interface implemented by types that store account
+// credentials. Implementations must be safe for concurrent use.
+type CredentialStore interface {
+	// Account returns the account with the given username. It returns
+	// ErrAccountNotFound if it does not exist.
+	Account(username string) (Account, error)
+	// PutAccount creates or replaces an account.
+	PutAccount(a Account) error
+	// DeleteAccount removes an account. It returns ErrAccountNotFound if it
+	// does not exist.
+	DeleteAccount(username string) error
+}
+
+// CredentialParams are the parameters used to derive credentials from a
+// password.
+type CredentialParams struct {
+	Iterations int
+	SaltSize   int
+	// Hashes are the SCRAM hashes keys are derived for.
+	Hashes []Hash
+	// Plain determines if a PlainCredential is derived.
+	Plain bool
+}
+
+// DefaultCredentialParams are the parameters recommended by RFC7677.
+var DefaultCredentialParams = CredentialParams{
+	Iterations: 4096,
+	SaltSize:   16,
+	Hashes:     []Hash{SHA1, SHA256, SHA512},
+}
+
+// NewAccount derives the credentials of an account from its password.
+func NewAccount(username, password string, params CredentialParams) (Account, error) {
+	a := Account{Username: username, SCRAM: make(map[string]SCRAMCredential)}
+	for _, h := range params.Hashes {
+		salt, err := genSalt(params.SaltSize)
+		if err != nil {
+			return Account{}, err
+		}
+		salted := h.SaltPassword(password, salt, params.Iterations)
+		storedKey, serverKey := h.Keys(salted)
+		a.SCRAM[h.Name] = SCRAMCredential{
+			Salt:       salt,
+			Iterations: params.Iterations,
+			StoredKey:  storedKey,
+			ServerKey:  serverKey,
+		}
+	}
+	if params.Plain {
+		salt, err := genSalt(params.SaltSize)
+		if err != nil {
+			return Account{}, err
+		}
+		a.Plain = &PlainCredential{
+			Salt:       salt,
+			Iterations: params.Iterations,
+			Hash:       SHA256.SaltPassword(password, salt, params.Iterations),
+		}
+	}
+	return a, nil
+}
+
+// Verify reports whether password is the password of the account.
+func (a Account) Verify(password string) bool {
+	if a.Plain != nil {
+		hash := SHA256.SaltPassword(password, a.Plain.Salt, a.Plain.Iterations)
+		return hmac.Equal(hash, a.Plain.Hash)
+	}
+	for _, h := range []Hash{SHA512, SHA256, SHA1} {
+		cred, ok := a.SCRAM[h.Name]
+		if !ok {
+			continue
+		}
+		storedKey, _ := h.Keys(h.SaltPassword(password, cred.Salt, cred.Iterations))
+		return hmac.Equal(storedKey, cred.StoredKey)
+	}
+	return false
+}
+
+// outdated reports whether the credentials of the account were derived with
+// different parameters.
+func (a Account) outdated(params CredentialParams) bool {
+	if params.Plain != (a.Plain != nil) || len(params.Hashes) != len(a.SCRAM) {
+		return true
+	}
+	if a.Plain != nil && a.Plain.Iterations != params.Iterations {
+		return true
+	}
+	for _, h := range params.Hashes {
+		cred, ok := a.SCRAM[h.Name]
+		if !ok || cred.Iterations != params.Iterations {
+			return true
+		}
+	}
+	return false
+}
+
+func genSalt(size int) ([]byte, error) {
+	salt := make([]byte, size)
+	_, err := rand.Read(salt)
+	return salt, err
+}
+
+// StoreAuthenticator is a PlainAuthenticator that verifies passwords with the
+// credentials in a CredentialStore. When a password is verified for an
+// account whose credentials were derived with different parameters, the
+// credentials are derived again and stored.
+type StoreAuthenticator struct {
+	store  CredentialStore
+	params CredentialParams
+	domain string
+}
+
+// NewStoreAuthenticator creates a new StoreAuthenticator.
+func NewStoreAuthenticator(store CredentialStore, params CredentialParams) StoreAuthenticator {
+	return StoreAuthenticator{store: store, params: params}
+}
+
+// SetDomain sets the domain of the accounts in the store. If it is set, an
+// identity must be on this domain.
+func (sa StoreAuthenticator) SetDomain(domain string) StoreAuthenticator {
+	sa.domain = domain
+	return sa
+}
+
+// Authenticate implements the PlainAuthenticator interface. The identity must
+// be empty or the bare JID of the user. The PLAIN mechanism also checks the
+// domain of the identity against the domain of the stream.
+func (sa StoreAuthenticator) Authenticate(identity, username, password string) error {
+	if identity != "" {
+		authz, err := jid.Parse(identity)
+		if err != nil || !authz.IsBare() {
+			return ErrInvalidAuthzid
+		}
+		domain := sa.domain
+		if domain == "" {
+			domain = authz.Domain()
+		}
+		user, err := userJID(username, domain)
+		if err != nil {
+			return err
+		}
+		if !authz.Equal(user) {
+			return ErrInvalidAuthzid
+		}
+	}
+	a, err := sa.store.Account(username)
+	switch {
+	case err == ErrAccountNotFound:
+		return ErrNotAuthorized
+	case err != nil:
+		return ErrTemporaryFailure
+	case !a.Verify(password):
+		return ErrNotAuthorized
+	case a.Disabled:
+		return ErrAccountDisabled
+	}
+	if a.outdated(sa.params) {
+		if rehashed, err := NewAccount(username, password, sa.params); err == nil {
+			rehashed.Disabled = a.Disabled
+			sa.store.PutAccount(rehashed)
+		}
+	}
+	return nil
+}
+
+// MemoryCredentialStore is an in memory CredentialStore implementation.
+type MemoryCredentialStore struct {
+	mu       sync.RWMutex
+	accounts map[string]Account
+}
+
+// NewMemoryCredentialStore creates a new, empty MemoryCredentialStore.
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{accounts: make(map[string]Account)}
+}
+
+// Account implements the CredentialStore interface.
+func (ms *MemoryCredentialStore) Account(username string) (Account, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	a, ok := ms.accounts[username]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return a, nil
+}
+
+// PutAccount implements the CredentialStore interface.
+func (ms *MemoryCredentialStore) PutAccount(a Account) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.accounts[a.Username] = a
+	return nil
+}
+
+// DeleteAccount implements the CredentialStore interface.
+func (ms *MemoryCredentialStore) DeleteAccount(username string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.accounts[username]; !ok {
+		return ErrAccountNotFound
+	}
+	delete(ms.accounts, username)
+	return nil
+}
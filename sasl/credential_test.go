@@ -0,0 +1,238 @@
+package sasl
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+func TestStoreAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryCredentialStore()
+	old := CredentialParams{Iterations: 1024, SaltSize: 16, Hashes: []Hash{SHA1}}
+	a, err := NewAccount("juliet", "secret", old)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	store.PutAccount(a)
+	sa := NewStoreAuthenticator(store, DefaultCredentialParams)
+
+	// Should reject wrong passwords and unknown accounts.
+	if err = sa.Authenticate("", "juliet", "wrong"); err != ErrNotAuthorized {
+		t.Errorf("\nWant:%s\nGot :%v", ErrNotAuthorized, err)
+	}
+	if err = sa.Authenticate("", "romeo", "secret"); err != ErrNotAuthorized {
+		t.Errorf("\nWant:%s\nGot :%v", ErrNotAuthorized, err)
+	}
+
+	// Should verify the password and rehash with the new parameters.
+	if err = sa.Authenticate("", "juliet", "secret"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	a, _ = store.Account("juliet")
+	if a.outdated(DefaultCredentialParams) {
+		t.Error("Should rehash the credentials with the new parameters.")
+	}
+	if !a.Verify("secret") {
+		t.Error("Should verify the password with the rehashed credentials.")
+	}
+
+	// Should only accept the bare JID of the user as the identity.
+	sa = sa.SetDomain("example.com")
+	if err = sa.Authenticate("juliet@example.com", "juliet", "secret"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	for _, identity := range []string{"romeo@example.com", "juliet@example.net", "juliet@example.com/balcony"} {
+		if err = sa.Authenticate(identity, "juliet", "secret"); err != ErrInvalidAuthzid {
+			t.Errorf("\nWant:%s\nGot :%v", ErrInvalidAuthzid, err)
+		}
+	}
+
+	// Should reject disabled accounts.
+	a.Disabled = true
+	store.PutAccount(a)
+	if err = sa.Authenticate("", "juliet", "secret"); err != ErrAccountDisabled {
+		t.Errorf("\nWant:%s\nGot :%v", ErrAccountDisabled, err)
+	}
+	// Should not reveal that an account is disabled without the password.
+	if err = sa.Authenticate("", "juliet", "wrong"); err != ErrNotAuthorized {
+		t.Errorf("\nWant:%s\nGot :%v", ErrNotAuthorized, err)
+	}
+}
+
+func TestFileCredentialStore(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "nine-sasl")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "accounts.json")
+
+	fs, err := NewFileCredentialStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	params := CredentialParams{Iterations: 1024, SaltSize: 16, Hashes: []Hash{SHA256}, Plain: true}
+	a, _ := NewAccount("juliet", "secret", params)
+	if err = fs.PutAccount(a); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Should load the accounts written to the file.
+	fs, err = NewFileCredentialStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	a, err = fs.Account("juliet")
+	if err != nil || !a.Verify("secret") {
+		t.Error("Should load the accounts written to the file.")
+		t.Errorf("\nGot :%+v, %v", a, err)
+	}
+
+	// Should delete accounts.
+	if err = fs.DeleteAccount("juliet"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	fs, _ = NewFileCredentialStore(path)
+	if _, err = fs.Account("juliet"); err != ErrAccountNotFound {
+		t.Errorf("\nWant:%s\nGot :%v", ErrAccountNotFound, err)
+	}
+}
+
+func TestSCRAMMechanism(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryCredentialStore()
+	a, _ := NewAccount("juliet", "secret", CredentialParams{Iterations: 1024, SaltSize: 16, Hashes: []Hash{SHA256}})
+	store.PutAccount(a)
+	mech := NewSCRAMMechanism(SHA256, false, store)
+
+	exchange := func(password string) (stream.Properties, string) {
+		props := stream.Properties{Domain: "localhost"}
+		client := NewSCRAMClient(SHA256, false, Credentials{Username: "juliet", Password: password}).Start(props)
+		server := mech.Start(props)
+
+		first, _ := client.Next(nil)
+		elems, props, challenge := server.Authenticate(base64.StdEncoding.EncodeToString(first), props)
+		if !challenge {
+			return props, elems[0].Tag
+		}
+		data, _ := base64.StdEncoding.DecodeString(elems[0].Text())
+		final, err := client.Next(data)
+		if err != nil {
+			return props, err.Error()
+		}
+		elems, props, _ = server.Authenticate(base64.StdEncoding.EncodeToString(final), props)
+		if elems[0].Tag != "success" {
+			return props, elems[0].ChildElements()[0].Tag
+		}
+		data, _ = base64.StdEncoding.DecodeString(elems[0].Text())
+		if err = client.Verify(data); err != nil {
+			return props, err.Error()
+		}
+		return props, "success"
+	}
+
+	// Should authenticate against the stored keys.
+	props, got := exchange("secret")
+	if got != "success" || props.Status&stream.Auth == 0 || props.Header.To != "juliet@localhost" {
+		t.Error("Should authenticate against the stored keys.")
+		t.Errorf("\nWant:%s\nGot :%s", "success", got)
+	}
+
+	// Should reject a wrong password.
+	_, got = exchange("wrong")
+	if got != "not-authorized" {
+		t.Error("Should reject a wrong password.")
+		t.Errorf("\nWant:%s\nGot :%s", "not-authorized", got)
+	}
+
+	// Should send the same salt for every attempt on a missing account.
+	salt := func(user string) string {
+		server := mech.Start(stream.Properties{Domain: "localhost"})
+		first := base64.StdEncoding.EncodeToString([]byte("n,,n=" + user + ",r=abcdef"))
+		elems, _, _ := server.Authenticate(first, stream.Properties{Domain: "localhost"})
+		data, _ := base64.StdEncoding.DecodeString(elems[0].Text())
+		attrs, _ := parseAttrs(string(data))
+		return attrs['s']
+	}
+	if first, second := salt("romeo"), salt("romeo"); first != second {
+		t.Error("Should send the same salt for every attempt on a missing account.")
+		t.Errorf("\nWant:%s\nGot :%s", first, second)
+	}
+	if salt("romeo") == salt("mercutio") {
+		t.Error("Should send different salts for different missing accounts.")
+	}
+
+	// Should only report a disabled account once the proof is verified.
+	a.Disabled = true
+	store.PutAccount(a)
+	if _, got = exchange("wrong"); got != "not-authorized" {
+		t.Errorf("\nWant:%s\nGot :%s", "not-authorized", got)
+	}
+	if _, got = exchange("secret"); got != "account-disabled" {
+		t.Errorf("\nWant:%s\nGot :%s", "account-disabled", got)
+	}
+	a.Disabled = false
+	store.PutAccount(a)
+
+	// Should reject the y flag when a channel binding variant was advertised.
+	tlsProps := stream.Properties{
+		Domain: "localhost",
+		Status: stream.Secure,
+		TLS:    &tls.ConnectionState{Version: tls.VersionTLS13},
+	}
+	h := NewHandler(map[string]Mechanism{
+		SCRAMName(SHA256, false): mech,
+		SCRAMName(SHA256, true):  NewSCRAMMechanism(SHA256, true, store),
+	})
+	auth := element.New("auth").AddAttr("xmlns", namespace.SASL).
+		AddAttr("mechanism", SCRAMName(SHA256, false)).
+		SetText(base64.StdEncoding.EncodeToString([]byte("y,,n=juliet,r=abcdef")))
+	elems, _ := h.HandleElement(auth, tlsProps)
+	if len(elems) != 1 || elems[0].SelectElement("not-authorized").Tag == "" {
+		t.Error("Should reject the y flag when a channel binding variant was advertised.")
+		t.Errorf("\nGot :%s", elems)
+	}
+
+	// Should accept the y flag when no channel binding variant was advertised.
+	h = NewHandler(map[string]Mechanism{SCRAMName(SHA256, false): mech})
+	elems, _ = h.HandleElement(auth, tlsProps)
+	if len(elems) != 1 || elems[0].Tag != "challenge" {
+		t.Error("Should accept the y flag when no channel binding variant was advertised.")
+		t.Errorf("\nGot :%s", elems)
+	}
+
+	// Should only accept the bare JID of the user as the authzid.
+	for authzid, want := range map[string]string{
+		"juliet@localhost":         "challenge",
+		"romeo@localhost":          "failure",
+		"juliet@example.com":       "failure",
+		"juliet@localhost/balcony": "failure",
+	} {
+		auth = auth.SetText(base64.StdEncoding.EncodeToString([]byte("n,a=" + authzid + ",n=juliet,r=abcdef")))
+		elems, _ = h.HandleElement(auth, tlsProps)
+		if len(elems) != 1 || elems[0].Tag != want {
+			t.Errorf("\nWant:%s\nGot :%s", want, elems)
+		}
+	}
+
+	// Should reject usernames which are not valid localparts.
+	for _, user := range []string{"x/y", "a@b"} {
+		auth = auth.SetText(base64.StdEncoding.EncodeToString([]byte("n,,n=" + user + ",r=abcdef")))
+		elems, _ = h.HandleElement(auth, tlsProps)
+		if len(elems) != 1 || elems[0].SelectElement("not-authorized").Tag == "" {
+			t.Errorf("Username %q should fail with not-authorized.\nGot :%s", user, elems)
+		}
+	}
+}
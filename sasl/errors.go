@@ -0,0 +1,76 @@
+package sasl
+
+import (
+	"errors"
+
+	"github.com/skriptble/nine/element"
+)
+
+// The errors an authenticator can return to report why authentication
+// failed. Each one is reported to the initiating entity as the matching SASL
+// failure condition. Any other error is reported as not-authorized.
+var (
+	// ErrNotAuthorized is returned when the credentials are not valid.
+	ErrNotAuthorized = errors.New("sasl: not authorized")
+	// ErrAccountDisabled is returned when the account has been disabled.
+	ErrAccountDisabled = errors.New("sasl: account disabled")
+	// ErrCredentialsExpired is returned when the credentials are valid but
+	// have expired.
+	ErrCredentialsExpired = errors.New("sasl: credentials expired")
+	// ErrTemporaryFailure is returned when authentication could not be
+	// completed because of a temporary error, such as an unavailable backend.
+	ErrTemporaryFailure = errors.New("sasl: temporary authentication failure")
+	// ErrInvalidAuthzid is returned when the authorization identity is
+	// malformed or the user is not allowed to act as it.
+	ErrInvalidAuthzid = errors.New("sasl: invalid authzid")
+	// ErrEncryptionRequired is returned when the mechanism can only be used
+	// over an encrypted stream.
+	ErrEncryptionRequired = errors.New("sasl: encryption required")
+)
+
+// Error is an authentication error with a description for the initiating
+// entity. Err should be one of the errors defined in this package; Text is
+// sent in the text element of the failure.
+type Error struct {
+	Err  error
+	Text string
+}
+
+func (e Error) Error() string {
+	if e.Text == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Text
+}
+
+// Unwrap returns the underlying error.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// FailureFromError returns the SASL failure element for the given error.
+// Mechanisms use this to report errors returned by their authenticators.
+func FailureFromError(err error) element.Element {
+	var text string
+	var e Error
+	if errors.As(err, &e) {
+		text = e.Text
+	}
+
+	var condition element.Element
+	switch {
+	case errors.Is(err, ErrAccountDisabled), errors.Is(err, ErrAccountLocked):
+		condition = element.SASLFailure.AccountDisabled
+	case errors.Is(err, ErrCredentialsExpired):
+		condition = element.SASLFailure.CredentialsExpired
+	case errors.Is(err, ErrTemporaryFailure), errors.Is(err, ErrAddressLocked):
+		condition = element.SASLFailure.TemporaryAuthFailure
+	case errors.Is(err, ErrInvalidAuthzid):
+		condition = element.SASLFailure.InvalidAuthzid
+	case errors.Is(err, ErrEncryptionRequired):
+		condition = element.SASLFailure.EncryptionRequired
+	default:
+		condition = element.SASLFailure.NotAuthorized
+	}
+	return Failure(condition, text)
+}
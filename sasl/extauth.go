@@ -0,0 +1,210 @@
+package sasl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrExtAuthClosed is returned by ExtAuth after it has been closed.
+var ErrExtAuthClosed = errors.New("sasl: external authenticator closed")
+
+// ExtAuth is a PlainAuthenticator that verifies passwords with a long running
+// external program. It speaks the extauth protocol used by other XMPP servers:
+// every request and response is prefixed with its length as a big endian
+// uint16. Requests are auth:user:server:password and isuser:user:server, and
+// the response is a uint16 that is 1 on success and 0 otherwise.
+//
+// Requests are spread over a pool of processes. A process that fails or does
+// not respond within the timeout is killed and started again on the next
+// request.
+type ExtAuth struct {
+	domain  string
+	name    string
+	args    []string
+	timeout time.Duration
+
+	workers chan *extAuthWorker
+	done    chan struct{}
+	mu      sync.Mutex
+	closed  bool
+}
+
+// NewExtAuth starts workers processes of the program name with args for the
+// given domain. A timeout of zero means requests never time out.
+func NewExtAuth(domain string, workers int, timeout time.Duration, name string, args ...string) (*ExtAuth, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	ea := &ExtAuth{
+		domain:  domain,
+		name:    name,
+		args:    args,
+		timeout: timeout,
+		workers: make(chan *extAuthWorker, workers),
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		w := &extAuthWorker{}
+		if err := w.start(name, args); err != nil {
+			close(ea.workers)
+			for w := range ea.workers {
+				w.stop()
+			}
+			return nil, err
+		}
+		ea.workers <- w
+	}
+	return ea, nil
+}
+
+// Authenticate implements the PlainAuthenticator interface. The identity must
+// be empty or have the username as its localpart.
+func (ea *ExtAuth) Authenticate(identity, username, password string) error {
+	if identity != "" && identity != username+"@"+ea.domain {
+		return ErrInvalidAuthzid
+	}
+	if strings.ContainsRune(username, ':') {
+		return ErrNotAuthorized
+	}
+	ok, err := ea.request("auth:" + username + ":" + ea.domain + ":" + password)
+	switch {
+	case err != nil:
+		return ErrTemporaryFailure
+	case !ok:
+		return ErrNotAuthorized
+	}
+	return nil
+}
+
+// IsUser reports whether the account with the username exists.
+func (ea *ExtAuth) IsUser(username string) (bool, error) {
+	if strings.ContainsRune(username, ':') {
+		return false, nil
+	}
+	return ea.request("isuser:" + username + ":" + ea.domain)
+}
+
+// Close stops the processes. Requests in flight are allowed to finish,
+// requests waiting for a process fail with ErrExtAuthClosed.
+func (ea *ExtAuth) Close() error {
+	ea.mu.Lock()
+	if ea.closed {
+		ea.mu.Unlock()
+		return ErrExtAuthClosed
+	}
+	ea.closed = true
+	close(ea.done)
+	ea.mu.Unlock()
+
+	for i := 0; i < cap(ea.workers); i++ {
+		w := <-ea.workers
+		w.stop()
+	}
+	return nil
+}
+
+func (ea *ExtAuth) request(req string) (bool, error) {
+	var w *extAuthWorker
+	select {
+	case <-ea.done:
+		return false, ErrExtAuthClosed
+	case w = <-ea.workers:
+	}
+	defer func() { ea.workers <- w }()
+	if w.cmd == nil {
+		if err := w.start(ea.name, ea.args); err != nil {
+			return false, err
+		}
+	}
+
+	type result struct {
+		ok  bool
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ok, err := w.request(req)
+		done <- result{ok, err}
+	}()
+
+	var timeout <-chan time.Time
+	if ea.timeout > 0 {
+		timer := time.NewTimer(ea.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case res := <-done:
+		if res.err != nil {
+			w.stop()
+		}
+		return res.ok, res.err
+	case <-timeout:
+		// Killing the process unblocks the request goroutine.
+		w.cmd.Process.Kill()
+		<-done
+		w.stop()
+		return false, errors.New("sasl: external authenticator timed out")
+	}
+}
+
+// extAuthWorker is a single external authentication process.
+type extAuthWorker struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+func (w *extAuthWorker) start(name string, args []string) error {
+	cmd := exec.Command(name, args...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	w.cmd, w.in, w.out = cmd, in, bufio.NewReader(out)
+	return nil
+}
+
+func (w *extAuthWorker) stop() {
+	if w.cmd == nil {
+		return
+	}
+	w.in.Close()
+	w.cmd.Process.Kill()
+	w.cmd.Wait()
+	w.cmd = nil
+}
+
+func (w *extAuthWorker) request(req string) (bool, error) {
+	if len(req) > 0xffff {
+		return false, errors.New("sasl: external authenticator request too long")
+	}
+	b := make([]byte, 2+len(req))
+	binary.BigEndian.PutUint16(b, uint16(len(req)))
+	copy(b[2:], req)
+	if _, err := w.in.Write(b); err != nil {
+		return false, err
+	}
+
+	var resp [4]byte
+	if _, err := io.ReadFull(w.out, resp[:]); err != nil {
+		return false, err
+	}
+	if binary.BigEndian.Uint16(resp[:2]) != 2 {
+		return false, errors.New("sasl: invalid external authenticator response")
+	}
+	return binary.BigEndian.Uint16(resp[2:]) == 1, nil
+}
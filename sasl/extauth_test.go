@@ -0,0 +1,126 @@
+package sasl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExtAuthHelper is not a real test. It is the external authentication
+// program started by TestExtAuth.
+func TestExtAuthHelper(t *testing.T) {
+	if os.Getenv("NINE_EXTAUTH_HELPER") != "1" {
+		return
+	}
+	in := bufio.NewReader(os.Stdin)
+	for {
+		var l [2]byte
+		if _, err := io.ReadFull(in, l[:]); err != nil {
+			os.Exit(0)
+		}
+		req := make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(in, req); err != nil {
+			os.Exit(0)
+		}
+		parts := strings.SplitN(string(req), ":", 4)
+		var result uint16
+		switch {
+		case parts[1] == "slow":
+			time.Sleep(time.Second)
+		case parts[0] == "auth" && len(parts) == 4:
+			if parts[1] == "juliet" && parts[2] == "localhost" && parts[3] == "sec:ret" {
+				result = 1
+			}
+		case parts[0] == "isuser":
+			if parts[1] == "juliet" {
+				result = 1
+			}
+		}
+		var resp [4]byte
+		binary.BigEndian.PutUint16(resp[:2], 2)
+		binary.BigEndian.PutUint16(resp[2:], result)
+		os.Stdout.Write(resp[:])
+	}
+}
+
+func TestExtAuth(t *testing.T) {
+	os.Setenv("NINE_EXTAUTH_HELPER", "1")
+	defer os.Unsetenv("NINE_EXTAUTH_HELPER")
+	ea, err := NewExtAuth("localhost", 2, 100*time.Millisecond, os.Args[0], "-test.run=TestExtAuthHelper")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer ea.Close()
+
+	// Should authenticate with the external program.
+	if err = ea.Authenticate("", "juliet", "sec:ret"); err != nil {
+		t.Errorf("Should authenticate with the external program. Unexpected error: %s", err)
+	}
+	if err = ea.Authenticate("", "juliet", "wrong"); err != ErrNotAuthorized {
+		t.Errorf("\nWant:%s\nGot :%v", ErrNotAuthorized, err)
+	}
+
+	// Should check if users exist.
+	if ok, err := ea.IsUser("juliet"); !ok || err != nil {
+		t.Errorf("Should check if users exist. Got: %t, %v", ok, err)
+	}
+	if ok, _ := ea.IsUser("romeo"); ok {
+		t.Error("Should check if users exist.")
+	}
+
+	// Should time out and restart the process.
+	if err = ea.Authenticate("", "slow", "secret"); err != ErrTemporaryFailure {
+		t.Errorf("Should time out. Got: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = ea.Authenticate("", "juliet", "sec:ret"); err != nil {
+			t.Errorf("Should restart the process. Unexpected error: %s", err)
+		}
+	}
+}
+
+func TestExtAuthClose(t *testing.T) {
+	os.Setenv("NINE_EXTAUTH_HELPER", "1")
+	defer os.Unsetenv("NINE_EXTAUTH_HELPER")
+	ea, err := NewExtAuth("localhost", 1, 0, os.Args[0], "-test.run=TestExtAuthHelper")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Should let requests in flight finish and fail waiting requests.
+	inFlight := make(chan error, 1)
+	go func() { inFlight <- ea.Authenticate("", "slow", "secret") }()
+	time.Sleep(100 * time.Millisecond)
+	waiting := make(chan error, 1)
+	go func() { waiting <- ea.Authenticate("", "juliet", "sec:ret") }()
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- ea.Close() }()
+	select {
+	case err = <-waiting:
+		if err != ErrTemporaryFailure {
+			t.Errorf("\nWant:%s\nGot :%v", ErrTemporaryFailure, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Should fail requests waiting for a process when closed.")
+	}
+	if err = <-inFlight; err != ErrNotAuthorized {
+		t.Errorf("\nWant:%s\nGot :%v", ErrNotAuthorized, err)
+	}
+	if err = <-closed; err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// Should fail requests and Close after it has been closed.
+	if err = ea.Authenticate("", "juliet", "sec:ret"); err != ErrTemporaryFailure {
+		t.Errorf("\nWant:%s\nGot :%v", ErrTemporaryFailure, err)
+	}
+	if err = ea.Close(); err != ErrExtAuthClosed {
+		t.Errorf("\nWant:%s\nGot :%v", ErrExtAuthClosed, err)
+	}
+}
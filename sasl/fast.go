@@ -0,0 +1,314 @@
+package sasl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/jid"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+// ErrTokenNotFound is returned by a TokenStore when a token does not exist.
+var ErrTokenNotFound = errors.New("sasl: token not found")
+
+// Token is a FAST (XEP-0484) token issued to a user agent of an account.
+type Token struct {
+	Username  string
+	UserAgent string
+	Mechanism string
+	Value     string
+	Issued    time.Time
+	Expiry    time.Time
+	// Count is the highest count sent by the client when using this token.
+	// It protects against replayed authentication attempts.
+	Count int
+}
+
+// TokenStore is the interface implemented by types that can store FAST
+// tokens. Implementations must be safe for concurrent use.
+type TokenStore interface {
+	// Tokens returns the tokens issued to username.
+	Tokens(username string) ([]Token, error)
+	// Put stores the token, replacing any token of the same user with the
+	// same value.
+	Put(t Token) error
+	// Delete removes the token of username with the given value. It returns
+	// ErrTokenNotFound if the token does not exist.
+	Delete(username, value string) error
+	// UpdateCount atomically sets the count of the token of username with the
+	// given value if count is greater than the stored count. It reports
+	// whether the count was updated and returns ErrTokenNotFound if the token
+	// does not exist.
+	UpdateCount(username, value string, count int) (bool, error)
+}
+
+// MemoryTokenStore is an in memory TokenStore implementation.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string][]Token
+}
+
+// NewMemoryTokenStore creates a new, empty MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string][]Token)}
+}
+
+// Tokens implements the TokenStore interface.
+func (ms *MemoryTokenStore) Tokens(username string) ([]Token, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	tokens := make([]Token, len(ms.tokens[username]))
+	copy(tokens, ms.tokens[username])
+	return tokens, nil
+}
+
+// Put implements the TokenStore interface.
+func (ms *MemoryTokenStore) Put(t Token) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	tokens := ms.tokens[t.Username]
+	for i, tok := range tokens {
+		if tok.Value == t.Value {
+			tokens[i] = t
+			return nil
+		}
+	}
+	ms.tokens[t.Username] = append(tokens, t)
+	return nil
+}
+
+// Delete implements the TokenStore interface.
+func (ms *MemoryTokenStore) Delete(username, value string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	tokens := ms.tokens[username]
+	for i, tok := range tokens {
+		if tok.Value == value {
+			ms.tokens[username] = append(tokens[:i:i], tokens[i+1:]...)
+			return nil
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// UpdateCount implements the TokenStore interface.
+func (ms *MemoryTokenStore) UpdateCount(username, value string, count int) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	tokens := ms.tokens[username]
+	for i, tok := range tokens {
+		if tok.Value == value {
+			if count <= tok.Count {
+				return false, nil
+			}
+			tokens[i].Count = count
+			return true, nil
+		}
+	}
+	return false, ErrTokenNotFound
+}
+
+// FASTName returns the name of the FAST mechanism that uses the hash without
+// channel binding, for example HT-SHA-256-NONE.
+func FASTName(h Hash) string {
+	return "HT-" + h.Name + "-NONE"
+}
+
+// FAST issues FAST (XEP-0484) tokens inline with SASL2 authentication. It
+// implements the sasl.InlineFeature interface for the request-token element.
+// The tokens are used to authenticate with the mechanisms created by
+// NewFASTMechanism.
+type FAST struct {
+	store  TokenStore
+	ttl    time.Duration
+	hashes []Hash
+}
+
+// NewFAST creates a new FAST that issues tokens valid for ttl using store.
+// The hashes are the hashes of the FAST mechanisms advertised.
+func NewFAST(store TokenStore, ttl time.Duration, hashes ...Hash) FAST {
+	return FAST{store: store, ttl: ttl, hashes: hashes}
+}
+
+// Inline implements the InlineFeature interface.
+func (f FAST) Inline(_ stream.Properties) element.Element {
+	el := element.New("fast").AddAttr("xmlns", namespace.FAST)
+	for _, h := range f.hashes {
+		el = el.AddChild(element.New("mechanism").SetText(FASTName(h)))
+	}
+	return el
+}
+
+// HandleInline implements the InlineFeature interface. It issues a new token
+// for the authenticated user and user agent.
+func (f FAST) HandleInline(el element.Element, props stream.Properties) ([]element.Element, stream.Properties) {
+	mech := el.SelectAttrValue("mechanism", "")
+	var ok bool
+	for _, h := range f.hashes {
+		if FASTName(h) == mech {
+			ok = true
+			break
+		}
+	}
+	ua, _ := UserAgentFromProperties(props)
+	if !ok || ua.ID == "" {
+		// Tokens are bound to a user agent, so none can be issued.
+		return []element.Element{}, props
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return []element.Element{}, props
+	}
+	now := time.Now()
+	tok := Token{
+		Username:  jid.New(props.Header.To).Local(),
+		UserAgent: ua.ID,
+		Mechanism: mech,
+		Value:     base64.StdEncoding.EncodeToString(b),
+		Issued:    now,
+		Expiry:    now.Add(f.ttl),
+	}
+	if err := f.store.Put(tok); err != nil {
+		return []element.Element{}, props
+	}
+	token := element.New("token").
+		AddAttr("xmlns", namespace.FAST).
+		AddAttr("expiry", tok.Expiry.UTC().Format(time.RFC3339)).
+		AddAttr("token", tok.Value)
+	return []element.Element{token}, props
+}
+
+// fastMech implements the server side of the HT-*-NONE mechanisms used by
+// FAST. It can only be used with SASL2.
+type fastMech struct {
+	hash  Hash
+	store TokenStore
+}
+
+// NewFASTMechanism creates the FAST mechanism that uses the hash. It should be
+// registered with the name returned by FASTName. FAST mechanisms are not
+// advertised with the other mechanisms, only inside the fast element.
+func NewFASTMechanism(h Hash, store TokenStore) Mechanism {
+	return fastMech{hash: h, store: store}
+}
+
+// Unlisted implements the Unlisted interface. FAST mechanisms are only
+// advertised inside the fast element.
+func (fm fastMech) Unlisted() bool {
+	return true
+}
+
+// Start implements the Mechanism interface.
+func (fm fastMech) Start(props stream.Properties) Session {
+	var req element.Element
+	if st, ok := props.SASL.(stateHolder); ok {
+		req = st.saslState().req
+	}
+	return &fastSession{fastMech: fm, req: req}
+}
+
+type fastSession struct {
+	fastMech
+	req      element.Element
+	username string
+}
+
+// Username implements the Identifier interface.
+func (fs *fastSession) Username() string {
+	return fs.username
+}
+
+// Authenticate implements the Session interface. The data is the username
+// and the hashed token separated by a NUL byte.
+func (fs *fastSession) Authenticate(data string, props stream.Properties) ([]element.Element, stream.Properties, bool) {
+	fast := fs.req.SelectElement("fast")
+	if !fast.MatchNamespace(namespace.FAST) {
+		return []element.Element{FailureFromError(ErrNotAuthorized)}, props, false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return []element.Element{element.SASLFailure.IncorrectEncoding}, props, false
+	}
+	idx := bytes.IndexByte(decoded, 0)
+	if idx == -1 {
+		return []element.Element{element.SASLFailure.MalformedRequest}, props, false
+	}
+	fs.username = string(decoded[:idx])
+	j, err := userJID(fs.username, props.Domain)
+	if err != nil {
+		return []element.Element{FailureFromError(err)}, props, false
+	}
+	hashed := decoded[idx+1:]
+	count, _ := strconv.Atoi(fast.SelectAttrValue("count", "0"))
+
+	ua := parseUserAgent(fs.req.SelectElement("user-agent"))
+	tok, err := fs.validate(hashed, count, ua.ID)
+	if err != nil {
+		return []element.Element{FailureFromError(err)}, props, false
+	}
+
+	if fast.SelectAttrValue("invalidate", "") == "true" || fast.SelectAttrValue("invalidate", "") == "1" {
+		fs.store.Delete(tok.Username, tok.Value)
+	}
+
+	props.Header.To = j.String()
+	props.Status = props.Status | stream.Restart | stream.Auth
+	responder := fs.hash.hmac([]byte(tok.Value), []byte("Responder"))
+	success := element.SASLSuccess.SetText(base64.StdEncoding.EncodeToString(responder))
+	return []element.Element{success}, props, false
+}
+
+// validate finds the token used to create hashed. The token must have been
+// issued to the user agent, so a stolen token cannot be used by another
+// client. Tokens issued before it to the same user agent are removed,
+// completing a token rotation.
+func (fs *fastSession) validate(hashed []byte, count int, userAgent string) (Token, error) {
+	tokens, err := fs.store.Tokens(fs.username)
+	if err != nil {
+		return Token{}, ErrTemporaryFailure
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Issued.Before(tokens[j].Issued) })
+
+	now := time.Now()
+	for _, tok := range tokens {
+		if tok.Mechanism != FASTName(fs.hash) || now.After(tok.Expiry) {
+			continue
+		}
+		expected := fs.hash.hmac([]byte(tok.Value), []byte("Initiator"))
+		if subtle.ConstantTimeCompare(expected, hashed) != 1 {
+			continue
+		}
+		if userAgent == "" || tok.UserAgent != userAgent {
+			return Token{}, Error{Err: ErrNotAuthorized, Text: "FAST token was issued to another user agent"}
+		}
+		// The count is compared and stored in one operation, so concurrent
+		// attempts with the same count cannot both succeed.
+		updated, err := fs.store.UpdateCount(tok.Username, tok.Value, count)
+		switch {
+		case err == ErrTokenNotFound:
+			return Token{}, ErrNotAuthorized
+		case err != nil:
+			return Token{}, ErrTemporaryFailure
+		case !updated:
+			return Token{}, Error{Err: ErrNotAuthorized, Text: "Replayed FAST count"}
+		}
+		tok.Count = count
+		for _, old := range tokens {
+			if old.UserAgent == tok.UserAgent && old.Issued.Before(tok.Issued) {
+				fs.store.Delete(old.Username, old.Value)
+			}
+		}
+		return tok, nil
+	}
+	return Token{}, ErrNotAuthorized
+}
@@ -0,0 +1,147 @@
+package sasl
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+func TestFAST(t *testing.T) {
+	t.Parallel()
+
+	var got []element.Element
+	secure := stream.Properties{Domain: "localhost", Status: stream.Secure, TLS: &tls.ConnectionState{}}
+	props := secure
+
+	store := NewMemoryTokenStore()
+	fast := NewFAST(store, time.Hour, SHA256)
+	h := NewHandler(map[string]Mechanism{
+		"PLAIN":          NewPlainMechanism(FakePlain{}),
+		FASTName(SHA256): NewFASTMechanism(SHA256, store),
+	})
+	s2 := NewSASL2Handler(h).Inline(namespace.FAST, "request-token", fast)
+	ua := element.New("user-agent").AddAttr("id", "d4565fa7-4d72-4749-b3d3-740edbf87770")
+
+	// FAST mechanisms should only be advertised inside the fast element.
+	ftr := s2.GenerateFeature(props).Features[0]
+	if len(ftr.ChildElements()) != 2 ||
+		ftr.SelectElement("inline").SelectElement("fast").SelectElement("mechanism").Text() != "HT-SHA-256-NONE" {
+		t.Error("FAST mechanisms should only be advertised inside the fast element.")
+		t.Errorf("\nGot :%s", ftr)
+	}
+
+	// Should issue a token after authentication.
+	auth := element.New("authenticate").AddAttr("xmlns", namespace.SASL2).AddAttr("mechanism", "PLAIN").
+		AddChild(element.New("initial-response").
+			SetText(base64.StdEncoding.EncodeToString([]byte("\000juliet\000secret")))).
+		AddChild(ua).
+		AddChild(element.New("request-token").AddAttr("xmlns", namespace.FAST).
+			AddAttr("mechanism", "HT-SHA-256-NONE"))
+	got, props = s2.HandleElement(auth, props)
+	token := got[0].SelectElement("token").SelectAttrValue("token", "")
+	if token == "" {
+		t.Fatalf("Should issue a token after authentication. Got: %s", got)
+	}
+
+	fastAuth := func(token string, count string) []element.Element {
+		hashed := SHA256.hmac([]byte(token), []byte("Initiator"))
+		data := append([]byte("juliet\000"), hashed...)
+		auth := element.New("authenticate").AddAttr("xmlns", namespace.SASL2).
+			AddAttr("mechanism", "HT-SHA-256-NONE").
+			AddChild(element.New("initial-response").SetText(base64.StdEncoding.EncodeToString(data))).
+			AddChild(ua).
+			AddChild(element.New("fast").AddAttr("xmlns", namespace.FAST).AddAttr("count", count)).
+			AddChild(element.New("request-token").AddAttr("xmlns", namespace.FAST).
+				AddAttr("mechanism", "HT-SHA-256-NONE"))
+		got, _ := s2.HandleElement(auth, secure)
+		return got
+	}
+
+	// Should authenticate with the token and rotate it.
+	got = fastAuth(token, "1")
+	if len(got) != 1 || got[0].Tag != "success" {
+		t.Fatalf("Should authenticate with the token. Got: %s", got)
+	}
+	responder := base64.StdEncoding.EncodeToString(SHA256.hmac([]byte(token), []byte("Responder")))
+	if data := got[0].SelectElement("additional-data").Text(); data != responder {
+		t.Error("Should return the responder hash as additional data.")
+		t.Errorf("\nWant:%s\nGot :%s", responder, data)
+	}
+	rotated := got[0].SelectElement("token").SelectAttrValue("token", "")
+	if rotated == "" || rotated == token {
+		t.Errorf("Should issue a new token. Got: %s", got)
+	}
+
+	// Should reject tokens used by another user agent.
+	orig := ua
+	ua = element.New("user-agent").AddAttr("id", "b5a1ddfe-1e8e-4d8b-a0bd-6bd2fb1e2e4e")
+	got = fastAuth(rotated, "5")
+	if len(got) != 1 || got[0].Tag != "failure" {
+		t.Errorf("Should reject tokens used by another user agent. Got: %s", got)
+	}
+	ua = orig
+
+	// Should reject replayed counts.
+	got = fastAuth(token, "1")
+	if len(got) != 1 || got[0].Tag != "failure" {
+		t.Errorf("Should reject replayed counts. Got: %s", got)
+	}
+
+	// Should remove the old token once the new one is used.
+	got = fastAuth(rotated, "1")
+	if len(got) != 1 || got[0].Tag != "success" {
+		t.Errorf("Should authenticate with the new token. Got: %s", got)
+	}
+	got = fastAuth(token, "2")
+	if len(got) != 1 || got[0].Tag != "failure" {
+		t.Errorf("Should remove the old token once the new one is used. Got: %s", got)
+	}
+}
+
+func TestFASTConcurrentCount(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryTokenStore()
+	tok := Token{
+		Username:  "juliet",
+		UserAgent: "d4565fa7-4d72-4749-b3d3-740edbf87770",
+		Mechanism: FASTName(SHA256),
+		Value:     "token",
+		Issued:    time.Now(),
+		Expiry:    time.Now().Add(time.Hour),
+	}
+	store.Put(tok)
+	hashed := SHA256.hmac([]byte(tok.Value), []byte("Initiator"))
+
+	// Should accept a count only once when it is used concurrently.
+	var wg sync.WaitGroup
+	var accepted int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fs := NewFASTMechanism(SHA256, store).Start(stream.Properties{}).(*fastSession)
+			fs.username = "juliet"
+			if _, err := fs.validate(hashed, 1, tok.UserAgent); err == nil {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Error("Should accept a count only once when it is used concurrently.")
+		t.Errorf("\nWant:%d\nGot :%d", 1, accepted)
+	}
+
+	// Should not update the count of a missing token.
+	if _, err := store.UpdateCount("juliet", "missing", 2); err != ErrTokenNotFound {
+		t.Errorf("\nWant:%s\nGot :%v", ErrTokenNotFound, err)
+	}
+}
@@ -0,0 +1,120 @@
+package sasl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileCredentialStore is a CredentialStore that keeps accounts in a JSON file.
+// The file is rewritten atomically on every change by writing a temporary
+// file and renaming it over the original.
+type FileCredentialStore struct {
+	path string
+
+	mu       sync.RWMutex
+	accounts map[string]Account
+}
+
+// NewFileCredentialStore creates a FileCredentialStore backed by the file at
+// path. The file is created on the first change if it does not exist.
+func NewFileCredentialStore(path string) (*FileCredentialStore, error) {
+	fs := &FileCredentialStore{path: path, accounts: make(map[string]Account)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var accounts []Account
+	if err = json.Unmarshal(b, &accounts); err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		fs.accounts[a.Username] = a
+	}
+	return fs, nil
+}
+
+// Account implements the CredentialStore interface.
+func (fs *FileCredentialStore) Account(username string) (Account, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	a, ok := fs.accounts[username]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return a, nil
+}
+
+// PutAccount implements the CredentialStore interface.
+func (fs *FileCredentialStore) PutAccount(a Account) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	prev, existed := fs.accounts[a.Username]
+	fs.accounts[a.Username] = a
+	if err := fs.save(); err != nil {
+		if existed {
+			fs.accounts[a.Username] = prev
+		} else {
+			delete(fs.accounts, a.Username)
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteAccount implements the CredentialStore interface.
+func (fs *FileCredentialStore) DeleteAccount(username string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	prev, ok := fs.accounts[username]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	delete(fs.accounts, username)
+	if err := fs.save(); err != nil {
+		fs.accounts[username] = prev
+		return err
+	}
+	return nil
+}
+
+// save writes the accounts to the file. It must be called with mu held.
+func (fs *FileCredentialStore) save() error {
+	accounts := make([]Account, 0, len(fs.accounts))
+	for _, a := range fs.accounts {
+		accounts = append(accounts, a)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Username < accounts[j].Username })
+	b, err := json.MarshalIndent(accounts, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path)
+}
@@ -1,7 +1,7 @@
 package sasl
 
 import (
-	"encoding/base64"
+	"fmt"
 	"log"
 	"strings"
 
@@ -10,106 +10,253 @@ import (
 	"github.com/skriptble/nine/stream"
 )
 
+// DefaultMaxFailures is the number of failed authentication attempts a
+// Handler allows on a stream before closing it. RFC6120 recommends allowing
+// between 2 and 5 retries.
+const DefaultMaxFailures = 3
+
+// Handler handles SASL negotiation for a stream. A single Handler can be
+// shared by every stream of a server; the state of an in-progress
+// authentication is stored on the stream's properties.
 type Handler struct {
-	mechs   map[string]Mechanism
-	current Mechanism
+	mechs       map[string]Mechanism
+	reqs        map[string]Requirement
+	order       []string
+	maxFailures int
+	lockout     Lockout
 }
 
-// Mechanism is the interface implemented by SASL Mechanisms.
+// Mechanism is the interface implemented by SASL Mechanisms. A Mechanism is a
+// factory for Sessions and must be safe for concurrent use.
 type Mechanism interface {
+	// Start is called when an auth element naming this mechanism is recieved.
+	// It returns a new Session that handles a single authentication exchange.
+	Start(props stream.Properties) Session
+}
+
+// Session is the interface implemented by a single authentication exchange of
+// a SASL mechanism. Sessions can hold state, such as nonces, between steps of
+// a multi-step mechanism.
+type Session interface {
 	// Authenticate is the method used when data is recieved. Elements returned
 	// are written directly to the stream. The modified stream properties will
-	// be assigned to the stream. If challenge is true, this mechanism will be
+	// be assigned to the stream. If challenge is true, this session will be
 	// used for any subsequent response elements.
 	Authenticate(data string, props stream.Properties) (elems []element.Element, p stream.Properties, challenge bool)
 }
 
+// Identifier is an optional interface implemented by Sessions that know which
+// account is being authenticated. The Handler uses it to apply per-account
+// lockouts.
+type Identifier interface {
+	// Username returns the username being authenticated, or an empty string
+	// if it is not yet known.
+	Username() string
+}
+
+// MechanismFunc is an adapter to allow the use of ordinary functions as
+// Mechanisms.
+type MechanismFunc func(props stream.Properties) Session
+
+// Start implements the Mechanism interface by calling mf(props).
+func (mf MechanismFunc) Start(props stream.Properties) Session {
+	return mf(props)
+}
+
+// state is the per-stream state of SASL negotiation. It is stored in the SASL
+// field of the stream's properties.
+type state struct {
+	sess     Session
+	failures int
+	// req is the SASL2 authenticate element when the exchange was started
+	// by a SASL2Handler.
+	req element.Element
+}
+
+// stateHolder is implemented by the per-stream states of the SASL handlers.
+// Both embed state, so the failures on a stream are counted together no
+// matter which protocol the initiating entity uses.
+type stateHolder interface {
+	saslState() state
+}
+
+func (s state) saslState() state {
+	return s
+}
+
+// NewHandler creates a new SASL Handler for the given mechanisms. The keys of
+// mechs are the names of the mechanisms advertised to the initiating entity.
+func NewHandler(mechs map[string]Mechanism) *Handler {
+	return &Handler{mechs: mechs, maxFailures: DefaultMaxFailures}
+}
+
+// SetMaxFailures sets the number of failed authentication attempts allowed
+// before the stream is closed with a policy-violation stream error. A value
+// less than one removes the limit.
+func (h *Handler) SetMaxFailures(n int) *Handler {
+	h.maxFailures = n
+	return h
+}
+
+// SetLockout sets the Lockout used to track failed attempts across streams.
+func (h *Handler) SetLockout(l Lockout) *Handler {
+	h.lockout = l
+	return h
+}
+
+// Failure creates a SASL failure element from the given condition, one of the
+// element.SASLFailure elements, with an optional descriptive text.
+func Failure(condition element.Element, text string) element.Element {
+	if text == "" {
+		return condition
+	}
+	return element.SASL.Failure.
+		AddChild(condition.ChildElements()[0]).
+		AddChild(element.New("text").AddAttr("xml:lang", "en").SetText(text))
+}
+
+// GenerateFeature implements the stream.FeatureGenerator interface. Only the
+// mechanisms whose requirements are met by the stream are advertised.
 func (h *Handler) GenerateFeature(props stream.Properties) stream.Properties {
 	if props.Status&stream.Auth != 0 {
 		return props
 	}
 	mechs := element.SASLMechanisms
-	for name := range h.mechs {
+	for _, name := range h.available(props) {
 		mechs = mechs.AddChild(element.New("mechanism").SetText(name))
 	}
 	props.Features = append(props.Features, mechs)
 	return props
 }
 
+// HandleElement implements the stream.ElementHandler interface. It starts a
+// new Session for auth elements, hands response elements to the Session
+// stored on the stream's properties and cancels that Session for abort
+// elements.
 func (h *Handler) HandleElement(el element.Element, props stream.Properties) (
 	[]element.Element, stream.Properties) {
 	var elems []element.Element
-	var challenge bool
+	var st state
+	if s, ok := props.SASL.(stateHolder); ok {
+		st = s.saslState()
+	}
+
 	switch el.Tag {
 	case "auth":
+		if st.sess != nil {
+			// A new auth element implicitly aborts the current exchange.
+			st.sess = nil
+		}
 		mechName := el.SelectAttrValue("mechanism", "")
 		mech, ok := h.mechs[mechName]
 		if !ok {
-			elems = append(elems, element.SASLFailure.InvalidMechanism)
+			text := fmt.Sprintf("Mechanism %s is not supported", mechName)
+			elems = append(elems, Failure(element.SASLFailure.InvalidMechanism, text))
+			st.failures++
+			break
+		}
+		if err := h.requirements(mechName, mech).Satisfied(props); err != nil {
+			if err != ErrEncryptionRequired {
+				text := fmt.Sprintf("Mechanism %s is not available on this stream", mechName)
+				err = Error{Err: err, Text: text}
+			}
+			elems = append(elems, FailureFromError(err))
+			st.failures++
 			break
 		}
-		data := el.Text()
+		if h.lockout != nil {
+			if err := h.lockout.Check("", props.RemoteAddr); err != nil {
+				elems = append(elems, lockoutFailure(err))
+				break
+			}
+		}
 		log.Println("Authenticating")
-		elems, props, challenge = mech.Authenticate(data, props)
-		if challenge {
-			h.current = mech
+		st.sess = mech.Start(props)
+		if pa, ok := st.sess.(plusAdvertiser); ok {
+			pa.setPlusAdvertised(h.plusAdvertised(props))
 		}
+		elems, props, st = h.step(st, el.Text(), props)
 	case "response":
-		if h.current == nil {
-			el := element.SASLFailure.NotAuthorized.
-				AddChild(element.New("text").SetText("Out of order SASL element"))
-			elems = append(elems, el)
-		}
-		data := el.Text()
-		elems, props, challenge = h.current.Authenticate(data, props)
-		if !challenge {
-			h.current = nil
+		if st.sess == nil {
+			text := "Out of order SASL element"
+			elems = append(elems, Failure(element.SASLFailure.NotAuthorized, text))
+			break
 		}
+		elems, props, st = h.step(st, el.Text(), props)
+	case "abort":
+		st.sess = nil
+		elems = append(elems, element.SASLFailure.Aborted)
 	}
-	return elems, props
-}
-
-func NewHandler(mechs map[string]Mechanism) *Handler {
-	return &Handler{mechs: mechs}
-}
 
-// PlainMech implements the plain SASL mechanism from RFC4616
-type plainMech struct {
-	auth PlainAuthenticator
+	if h.maxFailures > 0 && st.failures >= h.maxFailures {
+		elems = append(elems, element.StreamError.PolicyViolation)
+		props.Status = props.Status | stream.Closed
+	}
+	props.SASL = st
+	if props.Status&stream.Auth != 0 {
+		props.SASL = nil
+	}
+	return elems, props
 }
 
-// NewPlainMechanism creates a new SASL plain mechanism
-func NewPlainMechanism(auth PlainAuthenticator) Mechanism {
-	return plainMech{auth: auth}
-}
+// step hands data to the current session and records the outcome.
+func (h *Handler) step(st state, data string, props stream.Properties) (
+	[]element.Element, stream.Properties, state) {
+	orig := props
+	elems, props, challenge := st.sess.Authenticate(data, props)
+	if challenge {
+		return elems, props, st
+	}
 
-// Authenticate implements the Mechanism interface for PlainMech
-func (pm plainMech) Authenticate(data string, props stream.Properties) ([]element.Element, stream.Properties, bool) {
-	decoded, err := base64.StdEncoding.DecodeString(data)
-	if err != nil {
-		return []element.Element{element.SASLFailure.MalformedRequest}, props, false
+	var username string
+	if id, ok := st.sess.(Identifier); ok {
+		username = id.Username()
+	}
+	st.sess = nil
+	if h.lockout != nil {
+		// The lockout is checked whatever the outcome, so a locked account
+		// gets the same failure for right and wrong credentials and cannot be
+		// used to guess the password. Attempts on a locked account are not
+		// recorded as failures.
+		if err := h.lockout.Check(username, orig.RemoteAddr); err != nil {
+			st.failures++
+			return []element.Element{lockoutFailure(err)}, orig, st
+		}
+	}
+	if props.Status&stream.Auth != 0 && orig.Status&stream.Auth == 0 {
+		if h.lockout != nil {
+			h.lockout.Succeed(username, orig.RemoteAddr)
+		}
+		return elems, props, st
 	}
 
-	res := strings.Split(string(decoded), "\000")
-	if len(res) != 3 {
-		return []element.Element{element.SASLFailure.MalformedRequest}, props, false
+	st.failures++
+	if h.lockout != nil {
+		h.lockout.Fail(username, orig.RemoteAddr)
 	}
-	identity, user, password := res[0], res[1], res[2]
-	err = pm.auth.Authenticate(identity, user, password)
-	// TODO: Handle different types of errors
-	if err != nil {
-		return []element.Element{element.SASLFailure.NotAuthorized}, props, false
+	return elems, props, st
+}
+
+// userJID returns the bare JID of the user on the domain. It returns
+// ErrNotAuthorized if the username is not a valid localpart, so a username
+// cannot authenticate as another identity or as an empty JID.
+func userJID(username, domain string) (jid.JID, error) {
+	if strings.ContainsAny(username, "@/") {
+		return jid.Empty, ErrNotAuthorized
 	}
-	if identity != "" {
-		user = identity
-	} else {
-		// TODO: Add a way to determine the address of the server for the domain
-		// part of the jid (do it better than this.)
-		user += "@" + props.Domain
+	j, err := jid.Parse(username + "@" + domain)
+	if err != nil || j.Local() == "" {
+		return jid.Empty, ErrNotAuthorized
 	}
+	return j, nil
+}
 
-	j := jid.New(user)
-	props.Header.To = j.String()
-	props.Status = props.Status | stream.Restart | stream.Auth
-	return []element.Element{element.SASLSuccess}, props, false
+func lockoutFailure(err error) element.Element {
+	switch err {
+	case ErrAccountLocked:
+		err = Error{Err: err, Text: "Too many failed attempts, the account has been temporarily disabled"}
+	default:
+		err = Error{Err: err, Text: "Too many failed attempts, try again later"}
+	}
+	return FailureFromError(err)
 }
@@ -0,0 +1,378 @@
+package sasl
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+// countSession is a two step Session that challenges once and then succeeds
+// if the response matches the data it was started with.
+type countSession struct {
+	nonce string
+	steps int
+}
+
+func (cs *countSession) Authenticate(data string, props stream.Properties) ([]element.Element, stream.Properties, bool) {
+	cs.steps++
+	if cs.steps == 1 {
+		cs.nonce = data
+		return []element.Element{element.New("challenge").SetText(data)}, props, true
+	}
+	if data != cs.nonce {
+		return []element.Element{element.SASLFailure.NotAuthorized}, props, false
+	}
+	props.Status = props.Status | stream.Auth
+	return []element.Element{element.SASLSuccess}, props, false
+}
+
+func TestHandlerSessions(t *testing.T) {
+	t.Parallel()
+
+	var want, got []element.Element
+	var p1, p2 stream.Properties
+
+	h := NewHandler(map[string]Mechanism{
+		"COUNT": MechanismFunc(func(stream.Properties) Session { return &countSession{} }),
+	})
+	auth := element.New("auth").AddAttr("xmlns", namespace.SASL).AddAttr("mechanism", "COUNT")
+	resp := element.New("response").AddAttr("xmlns", namespace.SASL)
+
+	// A single handler should keep a separate session for each stream.
+	_, p1 = h.HandleElement(auth.SetText("one"), p1)
+	_, p2 = h.HandleElement(auth.SetText("two"), p2)
+	if p1.SASL == nil || p2.SASL == nil {
+		t.Fatal("Challenging mechanisms should store their session on the properties.")
+	}
+
+	got, p2 = h.HandleElement(resp.SetText("two"), p2)
+	want = []element.Element{element.SASLSuccess}
+	if !reflect.DeepEqual(want, got) {
+		t.Error("A single handler should keep a separate session for each stream.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+	if p2.Status&stream.Auth == 0 || p2.SASL != nil {
+		t.Error("A completed session should authenticate the stream and be removed.")
+	}
+
+	got, p1 = h.HandleElement(resp.SetText("two"), p1)
+	want = []element.Element{element.SASLFailure.NotAuthorized}
+	if !reflect.DeepEqual(want, got) {
+		t.Error("Sessions should not share state between streams.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+
+	// A response without a session should return a failure.
+	got, _ = h.HandleElement(resp.SetText("one"), stream.Properties{})
+	if len(got) != 1 || got[0].SelectElement("not-authorized").Tag == "" {
+		t.Error("A response without a session should return a failure.")
+		t.Errorf("\nGot :%s", got)
+	}
+}
+
+func TestHandlerFailures(t *testing.T) {
+	t.Parallel()
+
+	var got []element.Element
+	var props stream.Properties
+
+	h := NewHandler(map[string]Mechanism{
+		"COUNT": MechanismFunc(func(stream.Properties) Session { return &countSession{} }),
+	}).SetMaxFailures(2)
+	auth := element.New("auth").AddAttr("xmlns", namespace.SASL).AddAttr("mechanism", "COUNT")
+	resp := element.New("response").AddAttr("xmlns", namespace.SASL)
+	abort := element.New("abort").AddAttr("xmlns", namespace.SASL)
+
+	// Abort should cancel the current session and return an aborted failure.
+	_, props = h.HandleElement(auth.SetText("one"), props)
+	got, props = h.HandleElement(abort, props)
+	if len(got) != 1 || got[0].SelectElement("aborted").Tag == "" {
+		t.Error("Abort should return an aborted failure.")
+		t.Errorf("\nGot :%s", got)
+	}
+	got, props = h.HandleElement(resp.SetText("one"), props)
+	if len(got) != 1 || got[0].SelectElement("text").Text() != "Out of order SASL element" {
+		t.Error("Abort should cancel the current session.")
+		t.Errorf("\nGot :%s", got)
+	}
+
+	// Unknown mechanisms should return a failure with text.
+	unknown := element.New("auth").AddAttr("xmlns", namespace.SASL).AddAttr("mechanism", "FOO")
+	got, props = h.HandleElement(unknown, props)
+	if len(got) != 1 || got[0].SelectElement("text").Text() == "" {
+		t.Error("Unknown mechanisms should return a failure with text.")
+		t.Errorf("\nGot :%s", got)
+	}
+
+	// Unknown mechanisms should count toward the maximum failures.
+	_, props = h.HandleElement(unknown, props)
+	if props.Status&stream.Closed == 0 {
+		t.Error("Unknown mechanisms should count toward the maximum failures.")
+	}
+	props = stream.Properties{}
+
+	// The stream should be closed once the maximum failures is reached.
+	_, props = h.HandleElement(auth.SetText("one"), props)
+	_, props = h.HandleElement(resp.SetText("two"), props)
+	if props.Status&stream.Closed != 0 {
+		t.Error("The stream should not be closed before the maximum failures is reached.")
+	}
+	_, props = h.HandleElement(auth.SetText("one"), props)
+	got, props = h.HandleElement(resp.SetText("two"), props)
+	if props.Status&stream.Closed == 0 {
+		t.Error("The stream should be closed once the maximum failures is reached.")
+	}
+	if len(got) != 2 || !reflect.DeepEqual(got[1], element.StreamError.PolicyViolation) {
+		t.Error("A policy-violation stream error should be returned.")
+		t.Errorf("\nGot :%s", got)
+	}
+}
+
+func TestHandlerLockout(t *testing.T) {
+	t.Parallel()
+
+	var got []element.Element
+	var props stream.Properties
+
+	l := NewMemoryLockout(2, time.Minute)
+	h := NewHandler(map[string]Mechanism{
+		"PLAIN": NewPlainMechanism(FakePlain{}),
+		"COUNT": MechanismFunc(func(stream.Properties) Session { return &countSession{} }),
+	}).SetLockout(l).Require("PLAIN", 0)
+	plain := element.New("auth").AddAttr("xmlns", namespace.SASL).AddAttr("mechanism", "PLAIN").
+		SetText(base64.StdEncoding.EncodeToString([]byte("\000foo\000bar")))
+
+	// Locked accounts should fail with account-disabled.
+	l.Fail("foo", "")
+	l.Fail("foo", "")
+	props.RemoteAddr = "127.0.0.1:1234"
+	got, props = h.HandleElement(plain, props)
+	if props.Status&stream.Auth != 0 {
+		t.Error("Locked accounts should not be authenticated.")
+	}
+	if len(got) != 1 || got[0].SelectElement("account-disabled").Tag == "" {
+		t.Error("Locked accounts should fail with account-disabled.")
+		t.Errorf("\nGot :%s", got)
+	}
+
+	// Locked accounts should fail the same way for wrong credentials, so the
+	// lockout cannot be used to guess the password.
+	wrong := NewHandler(map[string]Mechanism{
+		"PLAIN": NewPlainMechanism(errPlain{err: ErrNotAuthorized}),
+	}).SetLockout(l).Require("PLAIN", 0)
+	got, _ = wrong.HandleElement(plain, stream.Properties{RemoteAddr: "127.0.0.2:1234"})
+	if len(got) != 1 || got[0].SelectElement("account-disabled").Tag == "" {
+		t.Error("Locked accounts should fail the same way for wrong credentials.")
+		t.Errorf("\nGot :%s", got)
+	}
+	// Attempts on a locked account should not be recorded.
+	if err := l.Check("", "127.0.0.2:1234"); err != nil {
+		t.Errorf("Attempts on a locked account should not be recorded: %s", err)
+	}
+	wrong.HandleElement(plain, stream.Properties{RemoteAddr: "127.0.0.2:1234"})
+	if err := l.Check("", "127.0.0.2:1234"); err != nil {
+		t.Errorf("Attempts on a locked account should not be recorded: %s", err)
+	}
+
+	// Locked addresses should fail with temporary-auth-failure.
+	l.Fail("", "127.0.0.1:1234")
+	l.Fail("", "127.0.0.1:1234")
+	got, _ = h.HandleElement(plain, stream.Properties{RemoteAddr: "127.0.0.1:1234"})
+	if len(got) != 1 || got[0].SelectElement("temporary-auth-failure").Tag == "" {
+		t.Error("Locked addresses should fail with temporary-auth-failure.")
+		t.Errorf("\nGot :%s", got)
+	}
+}
+
+func TestMemoryLockoutWindow(t *testing.T) {
+	t.Parallel()
+
+	l := NewMemoryLockout(2, time.Minute).SetWindow(10 * time.Millisecond)
+
+	// Failures outside the window should not be counted together.
+	l.Fail("foo", "127.0.0.1:1234")
+	time.Sleep(20 * time.Millisecond)
+	l.Fail("foo", "127.0.0.1:1234")
+	if err := l.Check("foo", "127.0.0.1:1234"); err != nil {
+		t.Errorf("Failures outside the window should not be counted together: %s", err)
+	}
+
+	// Failures within the window should lock out.
+	l.Fail("foo", "127.0.0.1:1234")
+	if err := l.Check("foo", ""); err != ErrAccountLocked {
+		t.Errorf("\nWant:%s\nGot :%v", ErrAccountLocked, err)
+	}
+
+	// Stale entries should be swept.
+	l.Fail("bar", "127.0.0.2:1234")
+	time.Sleep(20 * time.Millisecond)
+	l.Fail("baz", "")
+	l.mu.Lock()
+	_, account := l.accounts["bar"]
+	_, addr := l.addrs["127.0.0.2:1234"]
+	l.mu.Unlock()
+	if account || addr {
+		t.Error("Stale entries should be swept.")
+	}
+	if err := l.Check("foo", ""); err != ErrAccountLocked {
+		t.Errorf("Locked entries should not be swept.\nWant:%s\nGot :%v", ErrAccountLocked, err)
+	}
+}
+
+type errPlain struct{ err error }
+
+func (ep errPlain) Authenticate(_, _, _ string) error { return ep.err }
+
+func TestPlainErrors(t *testing.T) {
+	t.Parallel()
+
+	data := base64.StdEncoding.EncodeToString([]byte("\000foo\000bar"))
+	tests := []struct {
+		err       error
+		condition string
+		text      string
+	}{
+		{errors.New("random error"), "not-authorized", ""},
+		{ErrAccountDisabled, "account-disabled", ""},
+		{ErrCredentialsExpired, "credentials-expired", ""},
+		{ErrTemporaryFailure, "temporary-auth-failure", ""},
+		{ErrInvalidAuthzid, "invalid-authzid", ""},
+		{ErrEncryptionRequired, "encryption-required", ""},
+		{Error{Err: ErrAccountDisabled, Text: "Banned"}, "account-disabled", "Banned"},
+		{fmt.Errorf("wrapped: %w", ErrCredentialsExpired), "credentials-expired", ""},
+	}
+
+	// Authenticator errors should be mapped to the matching failure condition.
+	for _, test := range tests {
+		sess := NewPlainMechanism(errPlain{err: test.err}).Start(stream.Properties{})
+		got, _, _ := sess.Authenticate(data, stream.Properties{})
+		if len(got) != 1 || got[0].SelectElement(test.condition).Tag == "" {
+			t.Errorf("Error %q should be mapped to %s.", test.err, test.condition)
+			t.Errorf("\nGot :%s", got)
+			continue
+		}
+		if text := got[0].SelectElement("text").Text(); text != test.text {
+			t.Errorf("Error %q should have text %q.", test.err, test.text)
+			t.Errorf("\nWant:%s\nGot :%s", test.text, text)
+		}
+	}
+}
+
+func TestPlainAuthzid(t *testing.T) {
+	t.Parallel()
+
+	props := stream.Properties{Domain: "example.com"}
+	tests := []struct {
+		identity string
+		ok       bool
+	}{
+		{"", true},
+		{"foo@example.com", true},
+		{"Foo@Example.com", true},
+		{"bar@example.com", false},
+		{"foo@victim.org", false},
+		{"foo@example.com/evil", false},
+		{"not a jid@", false},
+	}
+
+	// Users should only be able to authorize as themselves.
+	for _, test := range tests {
+		data := base64.StdEncoding.EncodeToString([]byte(test.identity + "\000foo\000bar"))
+		sess := NewPlainMechanism(FakePlain{}).Start(props)
+		got, p, _ := sess.Authenticate(data, props)
+		if ok := p.Status&stream.Auth != 0; ok != test.ok {
+			t.Errorf("Identity %q: want authenticated %t, got %t", test.identity, test.ok, ok)
+			continue
+		}
+		if test.ok {
+			if p.Header.To != "foo@example.com" {
+				t.Errorf("\nWant:%s\nGot :%s", "foo@example.com", p.Header.To)
+			}
+			continue
+		}
+		if len(got) != 1 || got[0].SelectElement("invalid-authzid").Tag == "" {
+			t.Errorf("Identity %q should fail with invalid-authzid.", test.identity)
+			t.Errorf("\nGot :%s", got)
+		}
+	}
+}
+
+func TestPlainUsername(t *testing.T) {
+	t.Parallel()
+
+	// Should reject usernames which are not valid localparts.
+	props := stream.Properties{Domain: "localhost"}
+	for _, user := range []string{"foo bar", "a@b", "\u2163", "x/y", ""} {
+		data := base64.StdEncoding.EncodeToString([]byte("\000" + user + "\000secret"))
+		sess := NewPlainMechanism(errPlain{}).Start(props)
+		got, p, _ := sess.Authenticate(data, props)
+		if p.Status&stream.Auth != 0 || len(got) != 1 || got[0].SelectElement("not-authorized").Tag == "" {
+			t.Errorf("Username %q should fail with not-authorized.", user)
+			t.Errorf("\nGot :%s %s", got, p.Header.To)
+		}
+	}
+}
+
+func TestHandlerPolicy(t *testing.T) {
+	t.Parallel()
+
+	var got []element.Element
+	var props stream.Properties
+
+	count := MechanismFunc(func(stream.Properties) Session { return &countSession{} })
+	h := NewHandler(map[string]Mechanism{
+		"PLAIN":         NewPlainMechanism(FakePlain{}),
+		"EXTERNAL":      count,
+		"SCRAM-SHA-1":   count,
+		"SCRAM-SHA-256": count,
+	}).Require("EXTERNAL", RequireClientCert).SetPreference("SCRAM-SHA-256")
+	mechNames := func(props stream.Properties) (names []string) {
+		props = h.GenerateFeature(props)
+		for _, m := range props.Features[0].ChildElements() {
+			names = append(names, m.Text())
+		}
+		return
+	}
+
+	// PLAIN should not be advertised on an unencrypted stream.
+	want := []string{"SCRAM-SHA-256", "SCRAM-SHA-1"}
+	if names := mechNames(props); !reflect.DeepEqual(want, names) {
+		t.Error("PLAIN should not be advertised on an unencrypted stream.")
+		t.Errorf("\nWant:%s\nGot :%s", want, names)
+	}
+
+	// PLAIN should be rejected with encryption-required on an unencrypted stream.
+	plain := element.New("auth").AddAttr("xmlns", namespace.SASL).AddAttr("mechanism", "PLAIN").
+		SetText(base64.StdEncoding.EncodeToString([]byte("\000foo\000bar")))
+	got, props = h.HandleElement(plain, props)
+	if props.Status&stream.Auth != 0 || len(got) != 1 ||
+		got[0].SelectElement("encryption-required").Tag == "" {
+		t.Error("PLAIN should be rejected with encryption-required on an unencrypted stream.")
+		t.Errorf("\nGot :%s", got)
+	}
+
+	// Mechanisms should be advertised in order of preference once secure.
+	props = stream.Properties{Status: stream.Secure, TLS: &tls.ConnectionState{}}
+	want = []string{"SCRAM-SHA-256", "PLAIN", "SCRAM-SHA-1"}
+	if names := mechNames(props); !reflect.DeepEqual(want, names) {
+		t.Error("Mechanisms should be advertised in order of preference once secure.")
+		t.Errorf("\nWant:%s\nGot :%s", want, names)
+	}
+
+	// EXTERNAL should be advertised when a client certificate is present.
+	props.TLS.PeerCertificates = []*x509.Certificate{{}}
+	want = []string{"SCRAM-SHA-256", "EXTERNAL", "PLAIN", "SCRAM-SHA-1"}
+	if names := mechNames(props); !reflect.DeepEqual(want, names) {
+		t.Error("EXTERNAL should be advertised when a client certificate is present.")
+		t.Errorf("\nWant:%s\nGot :%s", want, names)
+	}
+}
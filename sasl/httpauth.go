@@ -0,0 +1,158 @@
+package sasl
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPAuthenticator is a PlainAuthenticator that verifies passwords by
+// POSTing a form with the identity, username, domain and password fields to
+// a URL. The status code of the response is mapped to an error:
+//
+//	200, 204           success
+//	400, 422           ErrInvalidAuthzid
+//	401, 404           ErrNotAuthorized
+//	403, 423           ErrAccountDisabled
+//	419                ErrCredentialsExpired
+//	anything else      ErrTemporaryFailure
+//
+// Successful results are cached for a short time so clients that reconnect
+// often do not cause a request each time.
+type HTTPAuthenticator struct {
+	url    string
+	domain string
+	client *http.Client
+	tls    *tls.Config
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]time.Time
+}
+
+// maxHTTPAuthBody is the maximum number of bytes read from a response body.
+// Only the status code is used, the body is read so the connection can be
+// reused.
+const maxHTTPAuthBody = 64 << 10
+
+// DefaultHTTPCacheTTL is the default duration successful results of an
+// HTTPAuthenticator are cached for.
+const DefaultHTTPCacheTTL = time.Minute
+
+// NewHTTPAuthenticator creates a new HTTPAuthenticator that POSTs to the url
+// for accounts of the domain.
+func NewHTTPAuthenticator(url, domain string) *HTTPAuthenticator {
+	return &HTTPAuthenticator{
+		url:    url,
+		domain: domain,
+		client: &http.Client{Timeout: 10 * time.Second},
+		ttl:    DefaultHTTPCacheTTL,
+		cache:  make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// SetClient sets the http.Client used to make requests. A TLS configuration
+// set with SetTLSConfig is applied to a copy of the client.
+func (ha *HTTPAuthenticator) SetClient(c *http.Client) *HTTPAuthenticator {
+	ha.client = c
+	ha.applyTLSConfig()
+	return ha
+}
+
+// SetTLSConfig sets the TLS configuration used to connect to the URL. Client
+// certificates in the configuration are used for mutual TLS. The
+// configuration is applied to the transport of the client, which must be nil
+// or an *http.Transport.
+func (ha *HTTPAuthenticator) SetTLSConfig(cfg *tls.Config) *HTTPAuthenticator {
+	ha.tls = cfg
+	ha.applyTLSConfig()
+	return ha
+}
+
+func (ha *HTTPAuthenticator) applyTLSConfig() {
+	if ha.tls == nil {
+		return
+	}
+	var transport *http.Transport
+	switch t := ha.client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return
+	}
+	transport.TLSClientConfig = ha.tls
+	// The client is copied so a client passed to SetClient is not modified.
+	client := *ha.client
+	client.Transport = transport
+	ha.client = &client
+}
+
+// SetCacheTTL sets the duration successful results are cached for. A
+// duration of zero disables caching.
+func (ha *HTTPAuthenticator) SetCacheTTL(ttl time.Duration) *HTTPAuthenticator {
+	ha.ttl = ttl
+	return ha
+}
+
+// Authenticate implements the PlainAuthenticator interface.
+func (ha *HTTPAuthenticator) Authenticate(identity, username, password string) error {
+	key := sha256.Sum256([]byte(identity + "\x00" + username + "\x00" + password))
+	now := time.Now()
+	ha.mu.Lock()
+	expiry, ok := ha.cache[key]
+	if ok && now.After(expiry) {
+		delete(ha.cache, key)
+		ok = false
+	}
+	ha.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	form := url.Values{
+		"identity": {identity},
+		"username": {username},
+		"domain":   {ha.domain},
+		"password": {password},
+	}
+	resp, err := ha.client.Post(ha.url, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return ErrTemporaryFailure
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxHTTPAuthBody))
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ErrInvalidAuthzid
+	case http.StatusUnauthorized, http.StatusNotFound:
+		return ErrNotAuthorized
+	case http.StatusForbidden, http.StatusLocked:
+		return ErrAccountDisabled
+	case 419:
+		return ErrCredentialsExpired
+	default:
+		return ErrTemporaryFailure
+	}
+
+	if ha.ttl > 0 {
+		ha.mu.Lock()
+		for k, exp := range ha.cache {
+			if now.After(exp) {
+				delete(ha.cache, k)
+			}
+		}
+		ha.cache[key] = now.Add(ha.ttl)
+		ha.mu.Unlock()
+	}
+	return nil
+}
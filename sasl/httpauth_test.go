@@ -0,0 +1,108 @@
+package sasl
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Method != http.MethodPost || r.PostFormValue("domain") != "localhost" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.PostFormValue("username") {
+		case "juliet":
+			if r.PostFormValue("password") == "secret" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+		case "romeo":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	ha := NewHTTPAuthenticator(srv.URL, "localhost")
+	tests := []struct {
+		username, password string
+		want               error
+	}{
+		{"juliet", "secret", nil},
+		{"juliet", "wrong", ErrNotAuthorized},
+		{"romeo", "secret", ErrAccountDisabled},
+		{"tybalt", "secret", ErrTemporaryFailure},
+	}
+	// Should map status codes to errors.
+	for _, test := range tests {
+		got := ha.Authenticate("", test.username, test.password)
+		if got != test.want {
+			t.Errorf("\nWant:%v\nGot :%v", test.want, got)
+		}
+	}
+
+	// Should cache successful results.
+	before := atomic.LoadInt32(&requests)
+	if err := ha.Authenticate("", "juliet", "secret"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if after := atomic.LoadInt32(&requests); after != before {
+		t.Error("Should cache successful results.")
+	}
+}
+
+func TestHTTPAuthenticatorMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	// The server certificate doubles as the client certificate.
+	cfg := srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	cfg.Certificates = srv.TLS.Certificates
+
+	// Should authenticate with a client certificate.
+	ha := NewHTTPAuthenticator(srv.URL, "localhost").SetTLSConfig(cfg)
+	if err := ha.Authenticate("", "juliet", "secret"); err != nil {
+		t.Errorf("Should authenticate with a client certificate. Unexpected error: %s", err)
+	}
+
+	// Should apply the configuration to a client set before or after it.
+	client := &http.Client{Timeout: 5 * time.Second}
+	ha = NewHTTPAuthenticator(srv.URL, "localhost").SetClient(client).SetTLSConfig(cfg)
+	if err := ha.Authenticate("", "juliet", "secret"); err != nil {
+		t.Errorf("Should apply the configuration to the client. Unexpected error: %s", err)
+	}
+	if ha.client.Timeout != client.Timeout || client.Transport != nil {
+		t.Error("Should keep the client settings without modifying the client.")
+	}
+	ha = NewHTTPAuthenticator(srv.URL, "localhost").SetTLSConfig(cfg).SetClient(client)
+	if err := ha.Authenticate("", "juliet", "secret"); err != nil {
+		t.Errorf("Should apply the configuration to the client. Unexpected error: %s", err)
+	}
+
+	// Should fail without a client certificate.
+	ha = NewHTTPAuthenticator(srv.URL, "localhost").SetClient(srv.Client())
+	if err := ha.Authenticate("", "juliet", "secret"); err == nil {
+		t.Error("Should fail without a client certificate.")
+	}
+}
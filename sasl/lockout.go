@@ -0,0 +1,161 @@
+package sasl
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrAccountLocked is returned by a Lockout when the account being
+// authenticated has been locked out. It is reported to the initiating entity
+// as an account-disabled failure.
+var ErrAccountLocked = errors.New("account is locked out")
+
+// ErrAddressLocked is returned by a Lockout when the address of the
+// initiating entity has been locked out. It is reported to the initiating
+// entity as a temporary-auth-failure.
+var ErrAddressLocked = errors.New("address is locked out")
+
+// Lockout is the interface implemented by types that track failed
+// authentication attempts and lock out accounts or addresses that have failed
+// too many times. The username passed to a Lockout may be empty if it is not
+// yet known. Implementations must be safe for concurrent use.
+type Lockout interface {
+	// Check returns ErrAccountLocked or ErrAddressLocked if the username or
+	// addr are locked out, and nil otherwise.
+	Check(username, addr string) error
+	// Fail records a failed authentication attempt.
+	Fail(username, addr string)
+	// Succeed records a successful authentication attempt.
+	Succeed(username, addr string)
+}
+
+// MemoryLockout is an in memory Lockout implementation. Accounts and
+// addresses are locked out for a fixed duration once they reach the maximum
+// number of failed attempts within the failure window.
+type MemoryLockout struct {
+	max      int
+	duration time.Duration
+	window   time.Duration
+
+	mu        sync.Mutex
+	accounts  map[string]*lockoutEntry
+	addrs     map[string]*lockoutEntry
+	lastSweep time.Time
+}
+
+type lockoutEntry struct {
+	failures int
+	first    time.Time
+	until    time.Time
+}
+
+// NewMemoryLockout creates a new MemoryLockout which locks out accounts and
+// addresses for duration after max failed attempts. Failures are counted
+// within a window which defaults to duration.
+func NewMemoryLockout(max int, duration time.Duration) *MemoryLockout {
+	return &MemoryLockout{
+		max:       max,
+		duration:  duration,
+		window:    duration,
+		accounts:  make(map[string]*lockoutEntry),
+		addrs:     make(map[string]*lockoutEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// SetWindow sets the window within which failed attempts are counted. An
+// account or address whose first counted failure is older than the window
+// starts counting again.
+func (ml *MemoryLockout) SetWindow(window time.Duration) *MemoryLockout {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.window = window
+	return ml
+}
+
+// Check implements the Lockout interface.
+func (ml *MemoryLockout) Check(username, addr string) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	now := time.Now()
+	if username != "" && ml.locked(ml.accounts, username, now) {
+		return ErrAccountLocked
+	}
+	if addr != "" && ml.locked(ml.addrs, addr, now) {
+		return ErrAddressLocked
+	}
+	return nil
+}
+
+// Fail implements the Lockout interface.
+func (ml *MemoryLockout) Fail(username, addr string) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	now := time.Now()
+	ml.sweep(now)
+	if username != "" {
+		ml.fail(ml.accounts, username, now)
+	}
+	if addr != "" {
+		ml.fail(ml.addrs, addr, now)
+	}
+}
+
+// Succeed implements the Lockout interface. It resets the failure count of
+// the account and address.
+func (ml *MemoryLockout) Succeed(username, addr string) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	delete(ml.accounts, username)
+	delete(ml.addrs, addr)
+}
+
+func (ml *MemoryLockout) locked(m map[string]*lockoutEntry, key string, now time.Time) bool {
+	entry, ok := m[key]
+	if !ok || entry.until.IsZero() {
+		return false
+	}
+	if now.After(entry.until) {
+		delete(m, key)
+		return false
+	}
+	return true
+}
+
+func (ml *MemoryLockout) fail(m map[string]*lockoutEntry, key string, now time.Time) {
+	entry, ok := m[key]
+	if !ok || ml.stale(entry, now) {
+		entry = &lockoutEntry{first: now}
+		m[key] = entry
+	}
+	entry.failures++
+	if entry.failures >= ml.max {
+		entry.until = now.Add(ml.duration)
+	}
+}
+
+// stale reports whether entry is neither locked out nor within the failure
+// window.
+func (ml *MemoryLockout) stale(entry *lockoutEntry, now time.Time) bool {
+	if !entry.until.IsZero() {
+		return now.After(entry.until)
+	}
+	return now.Sub(entry.first) > ml.window
+}
+
+// sweep removes stale entries, at most once per window, so accounts and
+// addresses that never reach the maximum do not accumulate.
+func (ml *MemoryLockout) sweep(now time.Time) {
+	if now.Sub(ml.lastSweep) < ml.window {
+		return
+	}
+	ml.lastSweep = now
+	for _, m := range []map[string]*lockoutEntry{ml.accounts, ml.addrs} {
+		for key, entry := range m {
+			if ml.stale(entry, now) {
+				delete(m, key)
+			}
+		}
+	}
+}
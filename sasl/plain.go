@@ -0,0 +1,77 @@
+package sasl
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/jid"
+	"github.com/skriptble/nine/stream"
+)
+
+// PlainMech implements the plain SASL mechanism from RFC4616
+type plainMech struct {
+	auth PlainAuthenticator
+}
+
+// NewPlainMechanism creates a new SASL plain mechanism
+func NewPlainMechanism(auth PlainAuthenticator) Mechanism {
+	return plainMech{auth: auth}
+}
+
+// Requirements implements the Requirer interface for PlainMech. Since PLAIN
+// sends the password in the clear it is only offered on encrypted streams.
+func (pm plainMech) Requirements() Requirement {
+	return RequireTLS
+}
+
+// Start implements the Mechanism interface for PlainMech.
+func (pm plainMech) Start(_ stream.Properties) Session {
+	return &plainSession{auth: pm.auth}
+}
+
+// plainSession is a single authentication exchange of the plain mechanism.
+type plainSession struct {
+	auth     PlainAuthenticator
+	username string
+}
+
+// Username implements the Identifier interface for plainSession.
+func (ps *plainSession) Username() string {
+	return ps.username
+}
+
+// Authenticate implements the Session interface for plainSession.
+func (ps *plainSession) Authenticate(data string, props stream.Properties) ([]element.Element, stream.Properties, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return []element.Element{element.SASLFailure.MalformedRequest}, props, false
+	}
+
+	res := strings.Split(string(decoded), "\000")
+	if len(res) != 3 {
+		return []element.Element{element.SASLFailure.MalformedRequest}, props, false
+	}
+	identity, user, password := res[0], res[1], res[2]
+	ps.username = user
+	err = ps.auth.Authenticate(identity, user, password)
+	if err != nil {
+		return []element.Element{FailureFromError(err)}, props, false
+	}
+	// TODO: Add a way to determine the address of the server for the domain
+	// part of the jid (do it better than this.)
+	j, err := userJID(user, props.Domain)
+	if err != nil {
+		return []element.Element{FailureFromError(err)}, props, false
+	}
+	if identity != "" {
+		// Users can only authorize as themselves.
+		authz, err := jid.Parse(identity)
+		if err != nil || !authz.IsBare() || !authz.Equal(j) {
+			return []element.Element{FailureFromError(ErrInvalidAuthzid)}, props, false
+		}
+	}
+	props.Header.To = j.String()
+	props.Status = props.Status | stream.Restart | stream.Auth
+	return []element.Element{element.SASLSuccess}, props, false
+}
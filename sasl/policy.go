@@ -0,0 +1,139 @@
+package sasl
+
+import (
+	"crypto/tls"
+	"sort"
+	"strings"
+
+	"github.com/skriptble/nine/stream"
+)
+
+// Requirement is a set of conditions a stream must meet before a mechanism is
+// advertised or allowed on it.
+type Requirement int
+
+// The requirements a mechanism can have. They are implemented as bits so they
+// can be combined.
+const (
+	// RequireTLS requires the stream to be encrypted.
+	RequireTLS Requirement = 1 << iota
+	// RequireChannelBinding requires channel binding data to be available
+	// for the stream. This implies RequireTLS.
+	RequireChannelBinding
+	// RequireClientCert requires the initiating entity to have presented a
+	// client certificate. This implies RequireTLS.
+	RequireClientCert
+)
+
+// Requirer is an optional interface implemented by Mechanisms that have
+// requirements on the stream they are used on.
+type Requirer interface {
+	Requirements() Requirement
+}
+
+// Unlisted is an optional interface implemented by Mechanisms that are not
+// advertised with the other mechanisms, such as the FAST mechanisms which are
+// only advertised inside the fast element. Mechanisms wrapping another
+// mechanism should implement it if the wrapped mechanism does.
+type Unlisted interface {
+	Unlisted() bool
+}
+
+// Satisfied returns nil if the stream described by props meets the
+// requirements. It returns ErrEncryptionRequired if the stream is not
+// encrypted and ErrNotAuthorized if any other requirement is not met.
+func (r Requirement) Satisfied(props stream.Properties) error {
+	if r == 0 {
+		return nil
+	}
+	if props.Status&stream.Secure == 0 || props.TLS == nil {
+		return ErrEncryptionRequired
+	}
+	if r&RequireChannelBinding != 0 && !channelBindingAvailable(props.TLS) {
+		return ErrNotAuthorized
+	}
+	if r&RequireClientCert != 0 && len(props.TLS.PeerCertificates) == 0 {
+		return ErrNotAuthorized
+	}
+	return nil
+}
+
+// channelBindingAvailable reports whether tls-unique or tls-exporter channel
+// binding data can be derived from the connection.
+func channelBindingAvailable(cs *tls.ConnectionState) bool {
+	if cs.Version >= tls.VersionTLS13 {
+		return true
+	}
+	return len(cs.TLSUnique) > 0
+}
+
+// Require overrides the requirements for the named mechanism. This can be used
+// to add requirements to a mechanism or to relax the requirements a mechanism
+// declares itself, such as allowing PLAIN on an unencrypted stream.
+func (h *Handler) Require(name string, r Requirement) *Handler {
+	if h.reqs == nil {
+		h.reqs = make(map[string]Requirement)
+	}
+	h.reqs[name] = r
+	return h
+}
+
+// SetPreference sets the order in which mechanisms are advertised, most
+// preferred first. Mechanisms not named are advertised after those that are,
+// in alphabetical order.
+func (h *Handler) SetPreference(names ...string) *Handler {
+	h.order = names
+	return h
+}
+
+// requirements returns the requirements for the named mechanism.
+func (h *Handler) requirements(name string, mech Mechanism) Requirement {
+	if r, ok := h.reqs[name]; ok {
+		return r
+	}
+	if rq, ok := mech.(Requirer); ok {
+		return rq.Requirements()
+	}
+	return 0
+}
+
+// available returns the names of the mechanisms whose requirements are met by
+// the stream, in order of preference.
+func (h *Handler) available(props stream.Properties) []string {
+	rank := make(map[string]int, len(h.order))
+	for i, name := range h.order {
+		rank[name] = i + 1
+	}
+	var names []string
+	for name, mech := range h.mechs {
+		if ul, ok := mech.(Unlisted); ok && ul.Unlisted() {
+			continue
+		}
+		if h.requirements(name, mech).Satisfied(props) != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ri, rj := rank[names[i]], rank[names[j]]
+		switch {
+		case ri != 0 && rj != 0:
+			return ri < rj
+		case ri != 0 || rj != 0:
+			return ri != 0
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// plusAdvertised reports whether a channel binding variant of a mechanism is
+// advertised on the stream.
+func (h *Handler) plusAdvertised(props stream.Properties) bool {
+	for _, name := range h.available(props) {
+		if strings.HasSuffix(name, "-PLUS") {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,9 @@ package sasl
 // PlainAuthenticator is the interface implemented by types that can handle
 // authenticating users. It should be able to also handle authenticating a user
 // for a seperate identity than their username.
+//
+// Authenticate should return one of the errors defined in this package, such
+// as ErrAccountDisabled, to report why authentication failed.
 type PlainAuthenticator interface {
 	Authenticate(identity, username, password string) error
 }
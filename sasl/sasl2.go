@@ -0,0 +1,203 @@
+package sasl
+
+import (
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+// SASL2Handler handles Extensible SASL Profile (XEP-0388) negotiation for a
+// stream. It uses the mechanisms, policy and limits of a Handler, so both can
+// be offered on the same stream. Unlike RFC6120 SASL, the stream is not
+// restarted after a successful authentication, and other features can be
+// negotiated inline with the authenticate element.
+type SASL2Handler struct {
+	h      *Handler
+	inline []inlineEntry
+}
+
+type inlineEntry struct {
+	space, tag string
+	f          InlineFeature
+}
+
+// InlineFeature is the interface implemented by features that can be
+// negotiated inline with SASL2 authentication, such as Bind 2.
+type InlineFeature interface {
+	// Inline returns the element advertised inside the inline element of the
+	// authentication feature. If the returned element has an empty tag the
+	// feature is not advertised.
+	Inline(props stream.Properties) element.Element
+	// HandleInline is called after a successful authentication with the child
+	// of the authenticate element that requested the feature. The returned
+	// elements are added to the success element.
+	HandleInline(el element.Element, props stream.Properties) ([]element.Element, stream.Properties)
+}
+
+// UserAgent is the user agent sent by the initiating entity in the
+// authenticate element.
+type UserAgent struct {
+	ID       string
+	Software string
+	Device   string
+}
+
+// sasl2State is the per-stream state of SASL2 negotiation.
+type sasl2State struct {
+	state
+	ua UserAgent
+}
+
+// NewSASL2Handler creates a new SASL2Handler that uses the mechanisms,
+// policy and limits of h.
+func NewSASL2Handler(h *Handler) *SASL2Handler {
+	return &SASL2Handler{h: h}
+}
+
+// Inline registers the InlineFeature for children of the authenticate element
+// with the given space and tag.
+func (s2 *SASL2Handler) Inline(space, tag string, f InlineFeature) *SASL2Handler {
+	s2.inline = append(s2.inline, inlineEntry{space: space, tag: tag, f: f})
+	return s2
+}
+
+// UserAgentFromProperties returns the user agent sent by the initiating entity
+// if the stream was authenticated with SASL2.
+func UserAgentFromProperties(props stream.Properties) (UserAgent, bool) {
+	ua, ok := props.SASL.(UserAgent)
+	return ua, ok
+}
+
+// GenerateFeature implements the stream.FeatureGenerator interface.
+func (s2 *SASL2Handler) GenerateFeature(props stream.Properties) stream.Properties {
+	if props.Status&stream.Auth != 0 {
+		return props
+	}
+	ftr := element.New("authentication").AddAttr("xmlns", namespace.SASL2)
+	for _, name := range s2.h.available(props) {
+		ftr = ftr.AddChild(element.New("mechanism").SetText(name))
+	}
+	inline := element.New("inline")
+	for _, entry := range s2.inline {
+		if el := entry.f.Inline(props); el.Tag != "" {
+			inline = inline.AddChild(el)
+		}
+	}
+	if len(inline.Child) > 0 {
+		ftr = ftr.AddChild(inline)
+	}
+	props.Features = append(props.Features, ftr)
+	return props
+}
+
+// HandleElement implements the stream.ElementHandler interface. The SASL2
+// authenticate, response and abort elements are handed to the Handler as
+// their RFC6120 equivalents and the results are translated back.
+func (s2 *SASL2Handler) HandleElement(el element.Element, props stream.Properties) (
+	[]element.Element, stream.Properties) {
+	var st sasl2State
+	switch s := props.SASL.(type) {
+	case sasl2State:
+		st = s
+	case stateHolder:
+		st.state = s.saslState()
+	}
+
+	var req element.Element
+	switch el.Tag {
+	case "authenticate":
+		st.req = el
+		st.ua = parseUserAgent(el.SelectElement("user-agent"))
+		req = element.New("auth").
+			AddAttr("xmlns", namespace.SASL).
+			AddAttr("mechanism", el.SelectAttrValue("mechanism", "")).
+			SetText(el.SelectElement("initial-response").Text())
+	case "response":
+		req = element.New("response").AddAttr("xmlns", namespace.SASL).SetText(el.Text())
+	case "abort":
+		req = element.SASL.Abort
+	default:
+		return []element.Element{}, props
+	}
+
+	orig := props.Status
+	props.SASL = st.state
+	elems, props := s2.h.HandleElement(req, props)
+	if inner, ok := props.SASL.(state); ok {
+		st.state = inner
+	}
+	props.SASL = st
+
+	authenticated := props.Status&stream.Auth != 0 && orig&stream.Auth == 0
+	if authenticated {
+		// SASL2 does not restart the stream.
+		props.Status = props.Status &^ stream.Restart
+		props.SASL = st.ua
+	}
+
+	var res []element.Element
+	for _, elem := range elems {
+		switch elem.Tag {
+		case "challenge":
+			res = append(res, element.New("challenge").AddAttr("xmlns", namespace.SASL2).SetText(elem.Text()))
+		case "failure":
+			res = append(res, translateFailure(elem))
+		case "success":
+			var success element.Element
+			success, props = s2.success(elem, st, props)
+			res = append(res, success)
+		default:
+			res = append(res, elem)
+		}
+	}
+	return res, props
+}
+
+// success creates the SASL2 success element and negotiates the features
+// requested inline.
+func (s2 *SASL2Handler) success(elem element.Element, st sasl2State, props stream.Properties) (
+	element.Element, stream.Properties) {
+	var inlined []element.Element
+	for _, child := range st.req.ChildElements() {
+		for _, entry := range s2.inline {
+			if child.MatchNamespace(entry.space) && child.Tag == entry.tag {
+				var els []element.Element
+				els, props = entry.f.HandleInline(child, props)
+				inlined = append(inlined, els...)
+				break
+			}
+		}
+	}
+
+	success := element.New("success").AddAttr("xmlns", namespace.SASL2)
+	if data := elem.Text(); data != "" && data != "=" {
+		success = success.AddChild(element.New("additional-data").SetText(data))
+	}
+	success = success.AddChild(element.New("authorization-identifier").SetText(props.Header.To))
+	for _, el := range inlined {
+		success = success.AddChild(el)
+	}
+	return success, props
+}
+
+// translateFailure converts an RFC6120 failure into a SASL2 failure. The
+// condition keeps the RFC6120 SASL namespace.
+func translateFailure(elem element.Element) element.Element {
+	failure := element.New("failure").AddAttr("xmlns", namespace.SASL2)
+	for _, child := range elem.ChildElements() {
+		if child.Tag == "text" {
+			failure = failure.AddChild(child)
+			continue
+		}
+		failure = failure.AddChild(element.New(child.Tag).AddAttr("xmlns", namespace.SASL))
+	}
+	return failure
+}
+
+func parseUserAgent(el element.Element) UserAgent {
+	return UserAgent{
+		ID:       el.SelectAttrValue("id", ""),
+		Software: el.SelectElement("software").Text(),
+		Device:   el.SelectElement("device").Text(),
+	}
+}
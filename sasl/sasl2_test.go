@@ -0,0 +1,111 @@
+package sasl
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"testing"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+type fakeInline struct{}
+
+func (fi fakeInline) Inline(_ stream.Properties) element.Element {
+	return element.New("bind").AddAttr("xmlns", namespace.Bind2)
+}
+
+func (fi fakeInline) HandleInline(el element.Element, props stream.Properties) ([]element.Element, stream.Properties) {
+	props.Header.To += "/" + el.SelectElement("tag").Text()
+	props.Status = props.Status | stream.Bind
+	return []element.Element{element.New("bound").AddAttr("xmlns", namespace.Bind2)}, props
+}
+
+func TestSASL2Handler(t *testing.T) {
+	t.Parallel()
+
+	var got []element.Element
+	props := stream.Properties{Domain: "localhost", Status: stream.Secure, TLS: &tls.ConnectionState{}}
+
+	h := NewHandler(map[string]Mechanism{"PLAIN": NewPlainMechanism(FakePlain{})})
+	s2 := NewSASL2Handler(h).Inline(namespace.Bind2, "bind", fakeInline{})
+
+	// Should advertise the mechanisms and inline features.
+	ftrs := s2.GenerateFeature(props).Features
+	if len(ftrs) != 1 || ftrs[0].Tag != "authentication" ||
+		ftrs[0].SelectElement("mechanism").Text() != "PLAIN" ||
+		ftrs[0].SelectElement("inline").SelectElement("bind").Tag == "" {
+		t.Error("Should advertise the mechanisms and inline features.")
+		t.Errorf("\nGot :%s", ftrs)
+	}
+
+	// Should authenticate, bind inline and not restart the stream.
+	auth := element.New("authenticate").AddAttr("xmlns", namespace.SASL2).AddAttr("mechanism", "PLAIN").
+		AddChild(element.New("initial-response").
+			SetText(base64.StdEncoding.EncodeToString([]byte("\000juliet\000secret")))).
+		AddChild(element.New("user-agent").AddAttr("id", "d4565fa7-4d72-4749-b3d3-740edbf87770").
+			AddChild(element.New("software").SetText("AwesomeXMPP"))).
+		AddChild(element.New("bind").AddAttr("xmlns", namespace.Bind2).
+			AddChild(element.New("tag").SetText("phone")))
+	got, props = s2.HandleElement(auth, props)
+	if len(got) != 1 || got[0].Tag != "success" {
+		t.Fatalf("Should authenticate with SASL2. Got: %s", got)
+	}
+	if id := got[0].SelectElement("authorization-identifier").Text(); id != "juliet@localhost/phone" {
+		t.Error("Should return the bound authorization identifier.")
+		t.Errorf("\nWant:%s\nGot :%s", "juliet@localhost/phone", id)
+	}
+	if got[0].SelectElement("bound").Tag == "" {
+		t.Error("Should include the results of inline features.")
+	}
+	if props.Status&stream.Restart != 0 || props.Status&stream.Bind == 0 {
+		t.Error("Should bind inline and not restart the stream.")
+	}
+	ua, ok := UserAgentFromProperties(props)
+	if !ok || ua.Software != "AwesomeXMPP" || ua.ID != "d4565fa7-4d72-4749-b3d3-740edbf87770" {
+		t.Error("Should store the user agent.")
+		t.Errorf("\nGot :%+v", ua)
+	}
+
+	// Failures should keep the RFC6120 condition namespace.
+	props = stream.Properties{Domain: "localhost"}
+	got, _ = s2.HandleElement(auth, props)
+	if len(got) != 1 || !got[0].MatchNamespace(namespace.SASL2) ||
+		!got[0].SelectElement("encryption-required").MatchNamespace(namespace.SASL) {
+		t.Error("Failures should keep the RFC6120 condition namespace.")
+		t.Errorf("\nGot :%s", got)
+	}
+}
+
+func TestSASL2SharedFailures(t *testing.T) {
+	t.Parallel()
+
+	var props stream.Properties
+
+	h := NewHandler(map[string]Mechanism{
+		"PLAIN": NewPlainMechanism(errPlain{err: ErrNotAuthorized}),
+	}).Require("PLAIN", 0).SetMaxFailures(2)
+	s2 := NewSASL2Handler(h)
+	data := base64.StdEncoding.EncodeToString([]byte("\000juliet\000wrong"))
+	auth := element.New("auth").AddAttr("xmlns", namespace.SASL).AddAttr("mechanism", "PLAIN").SetText(data)
+	authenticate := element.New("authenticate").AddAttr("xmlns", namespace.SASL2).AddAttr("mechanism", "PLAIN").
+		AddChild(element.New("initial-response").SetText(data))
+
+	// Failures should be counted across RFC6120 SASL and SASL2.
+	_, props = h.HandleElement(auth, props)
+	if props.Status&stream.Closed != 0 {
+		t.Error("The stream should not be closed before the maximum failures is reached.")
+	}
+	_, props = s2.HandleElement(authenticate, props)
+	if props.Status&stream.Closed == 0 {
+		t.Error("Failures should be counted across RFC6120 SASL and SASL2.")
+	}
+
+	props = stream.Properties{}
+	_, props = s2.HandleElement(authenticate, props)
+	_, props = h.HandleElement(auth, props)
+	if props.Status&stream.Closed == 0 {
+		t.Error("Failures should be counted across SASL2 and RFC6120 SASL.")
+	}
+}
@@ -0,0 +1,539 @@
+package sasl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/jid"
+	"github.com/skriptble/nine/namespace"
+	"github.com/skriptble/nine/stream"
+)
+
+// ErrInvalidServerSignature is returned by a SCRAM client session when the
+// signature sent by the server does not match the expected signature.
+var ErrInvalidServerSignature = errors.New("sasl: invalid server signature")
+
+// ErrInvalidNonce is returned by a SCRAM session when the nonce sent by the
+// other party does not start with the expected nonce.
+var ErrInvalidNonce = errors.New("sasl: invalid nonce")
+
+// ErrMalformedMessage is returned by a SCRAM session when a message does not
+// follow the format from RFC5802.
+var ErrMalformedMessage = errors.New("sasl: malformed message")
+
+// ErrTooManyIterations is returned by a SCRAM client session when the server
+// asks for more than MaxSCRAMIterations iterations.
+var ErrTooManyIterations = errors.New("sasl: too many iterations")
+
+// MaxSCRAMIterations is the largest iteration count a SCRAM client accepts
+// from a server, so a malicious server cannot make it do unbounded work.
+const MaxSCRAMIterations = 1 << 20
+
+// Hash is a hash function that can be used with SCRAM mechanisms.
+type Hash struct {
+	// Name is the name of the hash as used in the mechanism name, for example
+	// SHA-256 for SCRAM-SHA-256.
+	Name string
+	New  func() hash.Hash
+}
+
+// The hash functions registered for SCRAM mechanisms.
+var (
+	SHA1   = Hash{Name: "SHA-1", New: sha1.New}
+	SHA256 = Hash{Name: "SHA-256", New: sha256.New}
+	SHA512 = Hash{Name: "SHA-512", New: sha512.New}
+)
+
+// SCRAMName returns the name of the SCRAM mechanism that uses the hash. If
+// plus is true the name of the channel binding variant is returned.
+func SCRAMName(h Hash, plus bool) string {
+	name := "SCRAM-" + h.Name
+	if plus {
+		name += "-PLUS"
+	}
+	return name
+}
+
+// SaltPassword derives the SaltedPassword from RFC5802 using PBKDF2 with the
+// HMAC of the hash.
+func (h Hash) SaltPassword(password string, salt []byte, iter int) []byte {
+	mac := hmac.New(h.New, []byte(password))
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	result := make([]byte, len(u))
+	copy(result, u)
+	for i := 1; i < iter; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+// Keys returns the StoredKey and ServerKey from RFC5802 for the salted
+// password.
+func (h Hash) Keys(saltedPassword []byte) (storedKey, serverKey []byte) {
+	clientKey := h.hmac(saltedPassword, []byte("Client Key"))
+	serverKey = h.hmac(saltedPassword, []byte("Server Key"))
+	storedKey = h.sum(clientKey)
+	return storedKey, serverKey
+}
+
+func (h Hash) hmac(key, data []byte) []byte {
+	mac := hmac.New(h.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (h Hash) sum(data []byte) []byte {
+	d := h.New()
+	d.Write(data)
+	return d.Sum(nil)
+}
+
+// channelBinding returns the channel binding type and data for the TLS
+// connection. tls-exporter is used for TLS 1.3 and tls-unique otherwise.
+func channelBinding(cs *tls.ConnectionState) (cbType string, data []byte, err error) {
+	if cs == nil {
+		return "", nil, ErrEncryptionRequired
+	}
+	if cs.Version >= tls.VersionTLS13 {
+		data, err = cs.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32)
+		return "tls-exporter", data, err
+	}
+	if len(cs.TLSUnique) == 0 {
+		return "", nil, ErrNotAuthorized
+	}
+	return "tls-unique", cs.TLSUnique, nil
+}
+
+// fakeSaltKey is the secret used to derive the salts of accounts that do not
+// exist. It is created once, so the same salt is sent for every attempt.
+var fakeSaltKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// fakeSalt derives the salt sent for an account that does not exist. It is
+// deterministic, so repeated attempts cannot tell it apart from a stored salt.
+func fakeSalt(h Hash, username string) []byte {
+	mac := hmac.New(sha256.New, fakeSaltKey)
+	mac.Write([]byte(h.Name + "\x00" + username))
+	return mac.Sum(nil)[:DefaultCredentialParams.SaltSize]
+}
+
+// genNonce creates a random printable nonce.
+func genNonce() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+// saslName escapes a username or authzid as described in RFC5802.
+func saslName(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
+
+// parseAttrs parses a SCRAM message into its attributes.
+func parseAttrs(msg string) (map[byte]string, error) {
+	attrs := make(map[byte]string)
+	for _, field := range strings.Split(msg, ",") {
+		if len(field) < 2 || field[1] != '=' {
+			return nil, ErrMalformedMessage
+		}
+		attrs[field[0]] = field[2:]
+	}
+	return attrs, nil
+}
+
+// scramClient is a single authentication exchange of the client side of a
+// SCRAM mechanism.
+type scramClient struct {
+	hash  Hash
+	plus  bool
+	creds Credentials
+	props stream.Properties
+
+	step            int
+	gs2Header       string
+	cbData          []byte
+	clientFirstBare string
+	nonce           string
+	serverSignature []byte
+}
+
+// scramClientMech implements the client side of the SCRAM mechanisms from
+// RFC5802 and RFC7677.
+type scramClientMech struct {
+	hash  Hash
+	plus  bool
+	creds Credentials
+}
+
+// NewSCRAMClient creates the client side of the SCRAM mechanism that uses the
+// hash. If plus is true the channel binding variant is created.
+func NewSCRAMClient(h Hash, plus bool, creds Credentials) ClientMechanism {
+	return scramClientMech{hash: h, plus: plus, creds: creds}
+}
+
+// Name implements the ClientMechanism interface.
+func (sm scramClientMech) Name() string {
+	return SCRAMName(sm.hash, sm.plus)
+}
+
+// Requirements implements the Requirer interface. The channel binding
+// variants can only be used when channel binding data is available.
+func (sm scramClientMech) Requirements() Requirement {
+	if sm.plus {
+		return RequireChannelBinding
+	}
+	return 0
+}
+
+// Start implements the ClientMechanism interface.
+func (sm scramClientMech) Start(props stream.Properties) ClientSession {
+	return &scramClient{hash: sm.hash, plus: sm.plus, creds: sm.creds, props: props}
+}
+
+// Next implements the ClientSession interface.
+func (sc *scramClient) Next(challenge []byte) ([]byte, error) {
+	sc.step++
+	switch sc.step {
+	case 1:
+		return sc.clientFirst()
+	case 2:
+		return sc.clientFinal(string(challenge))
+	}
+	return nil, ErrMalformedMessage
+}
+
+func (sc *scramClient) clientFirst() ([]byte, error) {
+	var authzid string
+	if sc.creds.Authzid != "" {
+		authzid = "a=" + saslName(sc.creds.Authzid)
+	}
+	sc.gs2Header = "n," + authzid + ","
+	if sc.plus {
+		cbType, data, err := channelBinding(sc.props.TLS)
+		if err != nil {
+			return nil, err
+		}
+		sc.gs2Header = "p=" + cbType + "," + authzid + ","
+		sc.cbData = data
+	} else if sc.props.TLS != nil && channelBindingAvailable(sc.props.TLS) {
+		// RFC5802 section 6: channel binding is supported but the receiving
+		// entity did not offer it, which lets it detect a stripped offer.
+		sc.gs2Header = "y," + authzid + ","
+	}
+	sc.nonce = genNonce()
+	sc.clientFirstBare = "n=" + saslName(sc.creds.Username) + ",r=" + sc.nonce
+	return []byte(sc.gs2Header + sc.clientFirstBare), nil
+}
+
+func (sc *scramClient) clientFinal(serverFirst string) ([]byte, error) {
+	attrs, err := parseAttrs(serverFirst)
+	if err != nil {
+		return nil, err
+	}
+	nonce, salt64, iter64 := attrs['r'], attrs['s'], attrs['i']
+	if !strings.HasPrefix(nonce, sc.nonce) || len(nonce) == len(sc.nonce) {
+		return nil, ErrInvalidNonce
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return nil, ErrMalformedMessage
+	}
+	iter, err := strconv.Atoi(iter64)
+	if err != nil || iter < 1 {
+		return nil, ErrMalformedMessage
+	}
+	if iter > MaxSCRAMIterations {
+		return nil, ErrTooManyIterations
+	}
+
+	cb := base64.StdEncoding.EncodeToString(append([]byte(sc.gs2Header), sc.cbData...))
+	withoutProof := "c=" + cb + ",r=" + nonce
+	authMessage := sc.clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	salted := sc.hash.SaltPassword(sc.creds.Password, salt, iter)
+	clientKey := sc.hash.hmac(salted, []byte("Client Key"))
+	storedKey, serverKey := sc.hash.Keys(salted)
+	proof := sc.hash.hmac(storedKey, []byte(authMessage))
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	sc.serverSignature = sc.hash.hmac(serverKey, []byte(authMessage))
+
+	return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// Verify implements the ClientSession interface by checking the server
+// signature in the server-final-message.
+func (sc *scramClient) Verify(data []byte) error {
+	attrs, err := parseAttrs(string(data))
+	if err != nil {
+		return err
+	}
+	if e, ok := attrs['e']; ok {
+		return Error{Err: ErrNotAuthorized, Text: e}
+	}
+	sig, err := base64.StdEncoding.DecodeString(attrs['v'])
+	if err != nil || sc.serverSignature == nil {
+		return ErrInvalidServerSignature
+	}
+	if subtle.ConstantTimeCompare(sig, sc.serverSignature) != 1 {
+		return ErrInvalidServerSignature
+	}
+	return nil
+}
+
+// unescapeSASLName reverses saslName.
+func unescapeSASLName(name string) (string, error) {
+	if strings.Count(name, "=") != strings.Count(name, "=3D")+strings.Count(name, "=2C") {
+		return "", ErrMalformedMessage
+	}
+	return strings.NewReplacer("=3D", "=", "=2C", ",").Replace(name), nil
+}
+
+// scramMech implements the server side of the SCRAM mechanisms from RFC5802
+// and RFC7677 using the keys in a CredentialStore.
+type scramMech struct {
+	hash  Hash
+	plus  bool
+	store CredentialStore
+}
+
+// NewSCRAMMechanism creates the server side of the SCRAM mechanism that uses
+// the hash. It should be registered with the name returned by SCRAMName. If
+// plus is true the channel binding variant is created.
+func NewSCRAMMechanism(h Hash, plus bool, store CredentialStore) Mechanism {
+	return scramMech{hash: h, plus: plus, store: store}
+}
+
+// Requirements implements the Requirer interface.
+func (sm scramMech) Requirements() Requirement {
+	if sm.plus {
+		return RequireChannelBinding
+	}
+	return 0
+}
+
+// Start implements the Mechanism interface.
+func (sm scramMech) Start(props stream.Properties) Session {
+	return &scramServer{scramMech: sm, props: props}
+}
+
+// scramServer is a single authentication exchange of the server side of a
+// SCRAM mechanism.
+type scramServer struct {
+	scramMech
+	props stream.Properties
+
+	step            int
+	username        string
+	user            jid.JID
+	authzid         string
+	gs2Header       string
+	cbData          []byte
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	cred            SCRAMCredential
+	disabled        bool
+	err             error
+	// plusAdvertised is set if a channel binding variant was advertised on
+	// the stream.
+	plusAdvertised bool
+}
+
+// plusAdvertiser is implemented by sessions that need to know if a channel
+// binding variant of a mechanism was advertised, to detect downgrades.
+type plusAdvertiser interface {
+	setPlusAdvertised(bool)
+}
+
+func (ss *scramServer) setPlusAdvertised(advertised bool) {
+	ss.plusAdvertised = advertised
+}
+
+// Username implements the Identifier interface.
+func (ss *scramServer) Username() string {
+	return ss.username
+}
+
+// Authenticate implements the Session interface.
+func (ss *scramServer) Authenticate(data string, props stream.Properties) ([]element.Element, stream.Properties, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return []element.Element{element.SASLFailure.IncorrectEncoding}, props, false
+	}
+	ss.step++
+	switch ss.step {
+	case 1:
+		serverFirst, err := ss.serverFirstMessage(string(decoded))
+		if err != nil {
+			return []element.Element{FailureFromError(err)}, props, false
+		}
+		challenge := element.New("challenge").
+			AddAttr("xmlns", namespace.SASL).
+			SetText(base64.StdEncoding.EncodeToString([]byte(serverFirst)))
+		return []element.Element{challenge}, props, true
+	case 2:
+		serverFinal, err := ss.serverFinalMessage(string(decoded))
+		if err != nil {
+			return []element.Element{FailureFromError(err)}, props, false
+		}
+		// The authzid, if any, was checked to be the same JID.
+		props.Header.To = ss.user.String()
+		props.Status = props.Status | stream.Restart | stream.Auth
+		success := element.SASLSuccess.SetText(base64.StdEncoding.EncodeToString([]byte(serverFinal)))
+		return []element.Element{success}, props, false
+	}
+	return []element.Element{element.SASLFailure.MalformedRequest}, props, false
+}
+
+func (ss *scramServer) serverFirstMessage(clientFirst string) (string, error) {
+	// gs2-header is cbind-flag "," [authzid] ","
+	parts := strings.SplitN(clientFirst, ",", 3)
+	if len(parts) != 3 {
+		return "", ErrMalformedMessage
+	}
+	cbFlag, authzid := parts[0], parts[1]
+	ss.gs2Header = cbFlag + "," + authzid + ","
+	ss.clientFirstBare = parts[2]
+
+	switch {
+	case strings.HasPrefix(cbFlag, "p="):
+		if !ss.plus {
+			return "", ErrMalformedMessage
+		}
+		cbType, data, err := channelBinding(ss.props.TLS)
+		if err != nil || cbType != cbFlag[2:] {
+			return "", Error{Err: ErrNotAuthorized, Text: "Unsupported channel binding type"}
+		}
+		ss.cbData = data
+	case ss.plus:
+		return "", Error{Err: ErrNotAuthorized, Text: "Channel binding is required"}
+	case cbFlag == "y" && ss.plusAdvertised:
+		// The client supports channel binding and thinks the server does
+		// not, so the mechanism list was tampered with.
+		return "", Error{Err: ErrNotAuthorized, Text: "Channel binding downgrade detected"}
+	case cbFlag != "n" && cbFlag != "y":
+		return "", ErrMalformedMessage
+	}
+	if authzid != "" {
+		if !strings.HasPrefix(authzid, "a=") {
+			return "", ErrMalformedMessage
+		}
+		name, err := unescapeSASLName(authzid[2:])
+		if err != nil || jid.New(name) == jid.Empty {
+			return "", ErrInvalidAuthzid
+		}
+		ss.authzid = name
+	}
+
+	attrs, err := parseAttrs(ss.clientFirstBare)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := attrs['m']; ok {
+		return "", ErrMalformedMessage
+	}
+	ss.username, err = unescapeSASLName(attrs['n'])
+	if err != nil || ss.username == "" || attrs['r'] == "" {
+		return "", ErrMalformedMessage
+	}
+	ss.user, err = userJID(ss.username, ss.props.Domain)
+	if err != nil {
+		return "", err
+	}
+	if ss.authzid != "" {
+		// Users can only authorize as themselves.
+		authz, err := jid.Parse(ss.authzid)
+		if err != nil || !authz.IsBare() || !authz.Equal(ss.user) {
+			return "", ErrInvalidAuthzid
+		}
+	}
+
+	a, err := ss.store.Account(ss.username)
+	cred, ok := a.SCRAM[ss.hash.Name]
+	switch {
+	case err == ErrAccountNotFound || (err == nil && !ok):
+		// Continue the exchange with a fake salt so it is not revealed that
+		// the account does not exist. The proof will never verify.
+		cred = SCRAMCredential{Salt: fakeSalt(ss.hash, ss.username), Iterations: DefaultCredentialParams.Iterations}
+		ss.err = ErrNotAuthorized
+	case err != nil:
+		return "", ErrTemporaryFailure
+	}
+	// A disabled account is only reported once the proof has been verified,
+	// so it is not revealed to someone who does not know the password.
+	ss.disabled = a.Disabled
+	ss.cred = cred
+
+	ss.nonce = attrs['r'] + genNonce()
+	ss.serverFirst = "r=" + ss.nonce +
+		",s=" + base64.StdEncoding.EncodeToString(cred.Salt) +
+		",i=" + strconv.Itoa(cred.Iterations)
+	return ss.serverFirst, nil
+}
+
+func (ss *scramServer) serverFinalMessage(clientFinal string) (string, error) {
+	idx := strings.LastIndex(clientFinal, ",p=")
+	if idx == -1 {
+		return "", ErrMalformedMessage
+	}
+	withoutProof, proof64 := clientFinal[:idx], clientFinal[idx+3:]
+	attrs, err := parseAttrs(withoutProof)
+	if err != nil {
+		return "", err
+	}
+	cb := base64.StdEncoding.EncodeToString(append([]byte(ss.gs2Header), ss.cbData...))
+	if attrs['c'] != cb {
+		return "", Error{Err: ErrNotAuthorized, Text: "Channel binding mismatch"}
+	}
+	if attrs['r'] != ss.nonce {
+		return "", ErrInvalidNonce
+	}
+	proof, err := base64.StdEncoding.DecodeString(proof64)
+	if err != nil {
+		return "", ErrMalformedMessage
+	}
+	if ss.err != nil {
+		return "", ss.err
+	}
+
+	authMessage := ss.clientFirstBare + "," + ss.serverFirst + "," + withoutProof
+	clientSignature := ss.hash.hmac(ss.cred.StoredKey, []byte(authMessage))
+	if len(proof) != len(clientSignature) {
+		return "", ErrNotAuthorized
+	}
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	if subtle.ConstantTimeCompare(ss.hash.sum(clientKey), ss.cred.StoredKey) != 1 {
+		return "", ErrNotAuthorized
+	}
+	if ss.disabled {
+		return "", ErrAccountDisabled
+	}
+	serverSignature := ss.hash.hmac(ss.cred.ServerKey, []byte(authMessage))
+	return "v=" + base64.StdEncoding.EncodeToString(serverSignature), nil
+}
@@ -0,0 +1,895 @@
+package stream
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/namespace"
+)
+
+// ErrElementTooLarge is returned from Decoder.Next when a top level element is
+// larger than the maximum element size.
+var ErrElementTooLarge = errors.New("element exceeds the maximum element size")
+
+// xmlURL is the namespace bound to the xml prefix.
+const xmlURL = "http://www.w3.org/XML/1998/namespace"
+
+// maxInterned is the number of names a Decoder interns. Names seen after the
+// table is full are allocated as usual so a peer cannot grow it without bound.
+const maxInterned = 1024
+
+// Decoder is an incremental tokenizer for XML streams. It reads the
+// restricted XML subset allowed by RFC6120 section 11 and decodes each top
+// level element straight into an element.Element. Comments, processing
+// instructions, DTDs and entity references other than the predefined entities
+// and character references are rejected with an *xml.SyntaxError, as are
+// invalid UTF-8, characters outside of the XML Char production and names
+// which are not qualified names. An XML declaration is only accepted before a
+// stream header, and CDATA sections only inside of elements.
+//
+// Element names and namespaces are resolved the same way encoding/xml does,
+// so the Space of a decoded element is its namespace. Names are interned per
+// decoder, so the names of elements seen repeatedly on a stream are only
+// allocated once.
+//
+// A Decoder is not tied to a transport. It only needs an io.Reader which
+// delivers the XML stream, so any transport which carries XMPP elements can
+// use it.
+type Decoder struct {
+	r   io.Reader
+	buf []byte
+	pos int
+	end int
+	// base is the stream offset of buf[0].
+	base int64
+	line int
+	err  error
+
+	names   map[string]string
+	scratch []byte
+	text    []byte
+	attrs   []qname
+
+	// bindings holds the namespace declarations in scope. marks holds the
+	// length of bindings before each open element declared its own.
+	bindings []binding
+	marks    []int
+	// stream is the name of the open stream header, if any. scope holds the
+	// namespace declarations of the stream header.
+	stream qname
+	scope  []binding
+	// declared is set after an XML declaration, the next top level element
+	// must be a stream header. A restarted stream may repeat the declaration.
+	declared bool
+
+	// discard is set while reading a Raw, the element is only validated and
+	// its bytes are collected in captured. inherited holds the declarations
+	// of the stream header the element uses.
+	discard   bool
+	capturing bool
+	captured  []byte
+	capStart  int
+	inherited []binding
+
+	max      int64
+	start    int64
+	limiting bool
+}
+
+type qname struct {
+	prefix, local string
+}
+
+type binding struct {
+	prefix, uri string
+}
+
+// NewDecoder creates a Decoder which reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:     r,
+		buf:   make([]byte, 4096),
+		line:  1,
+		names: make(map[string]string),
+	}
+}
+
+// newBytesDecoder creates a Decoder which reads the bytes of a single element
+// with the namespace declarations of scope in effect.
+func newBytesDecoder(b []byte, scope []binding) *Decoder {
+	return &Decoder{
+		r:        eofReader{},
+		buf:      b,
+		end:      len(b),
+		line:     1,
+		bindings: scope[:len(scope):len(scope)],
+	}
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// SetMaxElementSize sets the maximum size in bytes of a top level element.
+// Next returns ErrElementTooLarge for larger elements. A size of zero or less
+// disables the limit.
+func (d *Decoder) SetMaxElementSize(n int64) *Decoder {
+	d.max = n
+	return d
+}
+
+// InputOffset returns the offset in bytes of the current position in the
+// stream.
+func (d *Decoder) InputOffset() int64 {
+	return d.base + int64(d.pos)
+}
+
+// Next returns the next top level element of the stream. A stream header is
+// returned as soon as its start tag is read, without any children, and the
+// elements following it are returned by the next calls. The end of the stream
+// header is reported with ErrStreamClosed.
+//
+// Errors are sticky, once Next returned an error it returns the same error
+// on all following calls.
+func (d *Decoder) Next() (el element.Element, err error) {
+	if d.err != nil {
+		return el, d.err
+	}
+	el, err = d.next()
+	if err != nil {
+		d.err = err
+		return element.Element{}, err
+	}
+	return el, nil
+}
+
+// NextRaw returns the next top level element of the stream as a Raw. The
+// element is checked to be well formed like it is by Next, but it is not
+// decoded into an element.Element. This makes forwarding elements cheaper
+// when only their header attributes are needed. Prefixes the element inherits
+// from the stream header are declared on the element, so its bytes can be
+// written to another stream.
+//
+// Next and NextRaw can be mixed on the same Decoder.
+func (d *Decoder) NextRaw() (Raw, error) {
+	if d.err != nil {
+		return Raw{}, d.err
+	}
+	d.discard = true
+	el, err := d.next()
+	d.discard = false
+	if err != nil {
+		d.err = err
+		return Raw{}, err
+	}
+	return Raw{Space: el.Space, Tag: el.Tag, b: d.rawBytes(), scope: d.scope}, nil
+}
+
+// rawBytes returns a copy of the captured bytes with the inherited prefixes
+// declared on the root element. The default namespace is not declared, it is
+// the content namespace of the stream and differs between streams.
+func (d *Decoder) rawBytes() []byte {
+	size := len(d.captured)
+	for _, bd := range d.inherited {
+		size += len(" xmlns:='") + len(bd.prefix) + len(bd.uri) + 1
+	}
+	b := make([]byte, 0, size)
+	if len(d.inherited) == 0 {
+		return append(b, d.captured...)
+	}
+	i := nameEnd(d.captured)
+	b = append(b, d.captured[:i]...)
+	for _, bd := range d.inherited {
+		b = append(b, " xmlns:"...)
+		b = append(b, bd.prefix...)
+		b = append(b, "='"...)
+		b = element.AppendEscaped(b, bd.uri)
+		b = append(b, '\'')
+	}
+	return append(b, d.captured[i:]...)
+}
+
+func (d *Decoder) next() (el element.Element, err error) {
+	var b byte
+	for {
+		b, err = d.readByte()
+		if err != nil {
+			return
+		}
+		if b != '<' {
+			if !isSpace(b) {
+				return el, d.syntaxError("text outside of an element")
+			}
+			continue
+		}
+		start := d.InputOffset() - 1
+		b, err = d.readByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case '?':
+			if d.declared {
+				return el, d.syntaxError("XML declaration must be followed by a stream header")
+			}
+			err = d.declaration()
+			if err != nil {
+				return
+			}
+			d.declared = true
+		case '!':
+			err = d.cdataStart()
+			if err == nil {
+				err = d.syntaxError("CDATA section outside of an element")
+			}
+			return
+		case '/':
+			if d.declared {
+				return el, d.syntaxError("XML declaration must be followed by a stream header")
+			}
+			var name qname
+			name, err = d.endTag()
+			if err != nil {
+				return
+			}
+			if d.stream.local != "" && name == d.stream {
+				d.stream = qname{}
+				return el, ErrStreamClosed
+			}
+			return el, d.syntaxError("unexpected end element </" + name.String() + ">")
+		default:
+			d.unreadByte()
+			d.start, d.limiting = start, d.max > 0
+			if d.discard {
+				d.captured = append(d.captured[:0], '<')
+				d.capStart, d.capturing = d.pos, true
+				d.inherited = d.inherited[:0]
+			}
+			el, err = d.element()
+			if d.capturing {
+				d.captured = append(d.captured, d.buf[d.capStart:d.pos]...)
+				d.capturing = false
+			}
+			d.limiting = false
+			return
+		}
+	}
+}
+
+// element decodes an element. The opening < has already been read.
+func (d *Decoder) element() (el element.Element, err error) {
+	name, empty, el, err := d.startTag()
+	if err != nil {
+		return
+	}
+	el.Space = d.resolve(name.prefix, true)
+	el.Tag = name.local
+	if el.Tag == "stream" && el.Space == namespace.Stream {
+		// The open stream header keeps its mark, so a restart is at depth 2.
+		top := 1
+		if d.stream.local != "" {
+			top = 2
+		}
+		if len(d.marks) != top {
+			err = d.syntaxError("stream header inside of an element")
+			return
+		}
+		d.declared = false
+		// A stream header, or a restart, starts a new namespace scope for the
+		// rest of the stream.
+		n := len(d.bindings) - d.marks[len(d.marks)-1]
+		copy(d.bindings, d.bindings[len(d.bindings)-n:])
+		d.bindings, d.marks = d.bindings[:n], d.marks[:1]
+		d.marks[0] = 0
+		d.stream = name
+		d.scope = append([]binding(nil), d.bindings...)
+		return
+	}
+	if d.declared {
+		err = d.syntaxError("XML declaration must be followed by a stream header")
+		return
+	}
+	if !empty {
+		el.Child, err = d.children(name)
+	}
+	d.bindings = d.bindings[:d.marks[len(d.marks)-1]]
+	d.marks = d.marks[:len(d.marks)-1]
+	return
+}
+
+// startTag reads the name and attributes of a start tag and pushes the
+// namespace declarations of the element. The attributes of the returned
+// element are resolved.
+func (d *Decoder) startTag() (name qname, empty bool, el element.Element, err error) {
+	name, err = d.name()
+	if err != nil {
+		return
+	}
+	d.marks = append(d.marks, len(d.bindings))
+	d.attrs = d.attrs[:0]
+	var b byte
+	for {
+		b, err = d.skipSpace()
+		if err != nil {
+			return
+		}
+		if b == '>' {
+			break
+		}
+		if b == '/' {
+			empty = true
+			err = d.expect('>')
+			if err != nil {
+				return
+			}
+			break
+		}
+		d.unreadByte()
+		var attr qname
+		attr, err = d.name()
+		if err != nil {
+			return
+		}
+		for _, a := range d.attrs {
+			if a == attr {
+				err = d.syntaxError("attribute " + attr.String() + " redefined")
+				return
+			}
+		}
+		d.attrs = append(d.attrs, attr)
+		err = d.attrValue()
+		if err != nil {
+			return
+		}
+		xmlns := attr.prefix == "xmlns" || (attr.prefix == "" && attr.local == "xmlns")
+		if d.discard && !xmlns {
+			continue
+		}
+		value := string(d.text)
+		switch {
+		case attr.prefix == "xmlns":
+			d.bindings = append(d.bindings, binding{attr.local, value})
+		case xmlns:
+			d.bindings = append(d.bindings, binding{"", value})
+		}
+		if !d.discard {
+			el.Attr = append(el.Attr, element.Attr{Space: attr.prefix, Key: attr.local, Value: value})
+		}
+	}
+	for i, a := range el.Attr {
+		if a.Space != "" && a.Space != "xmlns" {
+			el.Attr[i].Space = d.resolve(a.Space, false)
+		}
+	}
+	if d.discard {
+		d.inherit(name.prefix)
+		for _, a := range d.attrs {
+			if a.prefix != "xmlns" {
+				d.inherit(a.prefix)
+			}
+		}
+	}
+	return
+}
+
+// inherit records the declaration of the prefix if the element being read as
+// a Raw inherits it from the stream header.
+func (d *Decoder) inherit(prefix string) {
+	if prefix == "" || prefix == "xml" {
+		return
+	}
+	for i := len(d.bindings) - 1; i >= 0; i-- {
+		if d.bindings[i].prefix != prefix {
+			continue
+		}
+		if i >= len(d.scope) {
+			return
+		}
+		for _, bd := range d.inherited {
+			if bd.prefix == prefix {
+				return
+			}
+		}
+		d.inherited = append(d.inherited, d.bindings[i])
+		return
+	}
+}
+
+// children reads the children of the element until its end tag.
+func (d *Decoder) children(name qname) (children []element.Token, err error) {
+	d.text = d.text[:0]
+	var b byte
+	// brackets counts the literal ] characters just read, since ]]> is not
+	// allowed in character data.
+	var brackets int
+	for {
+		b, err = d.readByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case '&':
+			brackets = 0
+			d.text, err = d.entity(d.text)
+			if err != nil {
+				return
+			}
+			continue
+		case '<':
+			brackets = 0
+		case ']':
+			brackets++
+			d.text = append(d.text, b)
+			continue
+		case '>':
+			if brackets >= 2 {
+				return children, d.syntaxError("]]> is not allowed in character data")
+			}
+			fallthrough
+		default:
+			brackets = 0
+			d.text = append(d.text, b)
+			continue
+		}
+
+		b, err = d.readByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case '!':
+			d.text, err = d.cdata(d.text)
+			if err != nil {
+				return
+			}
+			continue
+		case '?':
+			return children, d.syntaxError("processing instructions are not allowed")
+		}
+		if err = d.checkChars(d.text); err != nil {
+			return
+		}
+		if len(d.text) > 0 && !d.discard {
+			children = append(children, element.NewCharData(string(d.text)))
+		}
+		d.text = d.text[:0]
+		if b == '/' {
+			var end qname
+			end, err = d.endTag()
+			if err != nil {
+				return
+			}
+			if end != name {
+				err = d.syntaxError("element <" + name.String() + "> closed by </" + end.String() + ">")
+			}
+			return
+		}
+		d.unreadByte()
+		var child element.Element
+		child, err = d.element()
+		if err != nil {
+			return
+		}
+		if !d.discard {
+			children = append(children, child)
+		}
+		// The child used the text buffer for its own text and attributes.
+		d.text = d.text[:0]
+	}
+}
+
+// endTag reads the name of an end tag. The opening </ has already been read.
+func (d *Decoder) endTag() (name qname, err error) {
+	name, err = d.name()
+	if err != nil {
+		return
+	}
+	b, err := d.skipSpace()
+	if err != nil {
+		return
+	}
+	if b != '>' {
+		err = d.syntaxError("invalid character " + string(b) + " in end element")
+	}
+	return
+}
+
+// name reads an XML name and interns its prefix and local part.
+func (d *Decoder) name() (name qname, err error) {
+	d.scratch = d.scratch[:0]
+	colon := -1
+	for {
+		var b byte
+		b, err = d.readByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n', '/', '>', '=', '<', '\'', '"':
+			d.unreadByte()
+		case ':':
+			if colon < 0 {
+				colon = len(d.scratch)
+			}
+			d.scratch = append(d.scratch, b)
+			continue
+		default:
+			d.scratch = append(d.scratch, b)
+			continue
+		}
+		break
+	}
+	if !isName(d.scratch) {
+		err = d.syntaxError("invalid XML name: " + string(d.scratch))
+		return
+	}
+	local := d.scratch
+	if colon >= 0 {
+		name.prefix = d.intern(d.scratch[:colon])
+		local = d.scratch[colon+1:]
+	}
+	name.local = d.intern(local)
+	return
+}
+
+// attrValue reads the = and the quoted value of an attribute. The value is
+// left in the text buffer.
+func (d *Decoder) attrValue() error {
+	b, err := d.skipSpace()
+	if err != nil {
+		return err
+	}
+	if b != '=' {
+		return d.syntaxError("attribute name without = in element")
+	}
+	quote, err := d.skipSpace()
+	if err != nil {
+		return err
+	}
+	if quote != '\'' && quote != '"' {
+		return d.syntaxError("unquoted or missing attribute value in element")
+	}
+	d.text = d.text[:0]
+	for {
+		b, err = d.readByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case quote:
+			return d.checkChars(d.text)
+		case '<':
+			return d.syntaxError("unescaped < inside quoted string")
+		case '&':
+			d.text, err = d.entity(d.text)
+			if err != nil {
+				return err
+			}
+		default:
+			d.text = append(d.text, b)
+		}
+	}
+}
+
+// entity reads an entity or character reference and appends its value to
+// dst. The opening & has already been read.
+func (d *Decoder) entity(dst []byte) ([]byte, error) {
+	d.scratch = d.scratch[:0]
+	for {
+		b, err := d.readByte()
+		if err != nil {
+			return dst, err
+		}
+		if b == ';' {
+			break
+		}
+		if len(d.scratch) > 8 {
+			return dst, d.syntaxError("invalid character entity &" + string(d.scratch))
+		}
+		d.scratch = append(d.scratch, b)
+	}
+	switch string(d.scratch) {
+	case "lt":
+		return append(dst, '<'), nil
+	case "gt":
+		return append(dst, '>'), nil
+	case "amp":
+		return append(dst, '&'), nil
+	case "apos":
+		return append(dst, '\''), nil
+	case "quot":
+		return append(dst, '"'), nil
+	}
+	if len(d.scratch) < 2 || d.scratch[0] != '#' {
+		return dst, d.syntaxError("entity references are not allowed: &" + string(d.scratch) + ";")
+	}
+	var r rune
+	digits, base := d.scratch[1:], rune(10)
+	if digits[0] == 'x' {
+		digits, base = digits[1:], 16
+	}
+	if len(digits) == 0 {
+		return dst, d.syntaxError("invalid character entity &" + string(d.scratch) + ";")
+	}
+	for _, c := range digits {
+		var v rune
+		switch {
+		case c >= '0' && c <= '9':
+			v = rune(c - '0')
+		case base == 16 && c >= 'a' && c <= 'f':
+			v = rune(c-'a') + 10
+		case base == 16 && c >= 'A' && c <= 'F':
+			v = rune(c-'A') + 10
+		default:
+			return dst, d.syntaxError("invalid character entity &" + string(d.scratch) + ";")
+		}
+		r = r*base + v
+		if r > utf8.MaxRune {
+			return dst, d.syntaxError("invalid character entity &" + string(d.scratch) + ";")
+		}
+	}
+	if !isChar(r) {
+		return dst, d.syntaxError("invalid character entity &" + string(d.scratch) + ";")
+	}
+	var rb [utf8.UTFMax]byte
+	return append(dst, rb[:utf8.EncodeRune(rb[:], r)]...), nil
+}
+
+// cdata reads a CDATA section and appends its text to dst. The opening <! has
+// already been read.
+func (d *Decoder) cdata(dst []byte) ([]byte, error) {
+	if err := d.cdataStart(); err != nil {
+		return dst, err
+	}
+	n := len(dst)
+	for {
+		b, err := d.readByte()
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, b)
+		if b == '>' && len(dst)-n >= 3 && string(dst[len(dst)-3:]) == "]]>" {
+			return dst[:len(dst)-3], nil
+		}
+	}
+}
+
+// cdataStart reads the [CDATA[ which starts a CDATA section. The opening <!
+// has already been read. Comments and DTDs also start with <! and are
+// rejected.
+func (d *Decoder) cdataStart() error {
+	for i := 0; i < len("[CDATA["); i++ {
+		b, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		if b != "[CDATA["[i] {
+			if i == 0 && b == '-' {
+				return d.syntaxError("comments are not allowed")
+			}
+			return d.syntaxError("DTDs are not allowed")
+		}
+	}
+	return nil
+}
+
+// declaration skips an XML declaration. The opening <? has already been read.
+// Other processing instructions are rejected.
+func (d *Decoder) declaration() error {
+	var last byte
+	for i := 0; ; i++ {
+		b, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		if i < 4 && b != "xml "[i] && !(i == 3 && isSpace(b)) {
+			return d.syntaxError("processing instructions are not allowed")
+		}
+		if last == '?' && b == '>' {
+			return nil
+		}
+		last = b
+	}
+}
+
+// resolve returns the namespace bound to the prefix. Like encoding/xml an
+// unbound prefix is returned as is and unprefixed attributes have no
+// namespace.
+func (d *Decoder) resolve(prefix string, isElement bool) string {
+	if prefix == "" && !isElement {
+		return ""
+	}
+	if prefix == "xml" {
+		return xmlURL
+	}
+	for i := len(d.bindings) - 1; i >= 0; i-- {
+		if d.bindings[i].prefix == prefix {
+			return d.bindings[i].uri
+		}
+	}
+	return prefix
+}
+
+// intern returns b as a string, reusing the string if b was seen before.
+func (d *Decoder) intern(b []byte) string {
+	if d.names == nil {
+		return string(b)
+	}
+	if s, ok := d.names[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	if len(d.names) < maxInterned {
+		d.names[s] = s
+	}
+	return s
+}
+
+func (d *Decoder) skipSpace() (b byte, err error) {
+	for {
+		b, err = d.readByte()
+		if err != nil || !isSpace(b) {
+			return
+		}
+	}
+}
+
+func (d *Decoder) expect(c byte) error {
+	b, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if b != c {
+		return d.syntaxError("expected " + string(c) + " got " + string(b))
+	}
+	return nil
+}
+
+// readByte returns the next byte of the stream. The byte returned last can
+// be unread with unreadByte.
+func (d *Decoder) readByte() (byte, error) {
+	if d.pos == d.end {
+		if d.capturing {
+			d.captured = append(d.captured, d.buf[d.capStart:d.end]...)
+			d.capStart = 0
+		}
+		d.base += int64(d.end)
+		d.pos, d.end = 0, 0
+		n, err := d.r.Read(d.buf)
+		if n == 0 {
+			if err == nil {
+				err = io.ErrNoProgress
+			}
+			return 0, err
+		}
+		d.end = n
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	if d.limiting && d.InputOffset()-d.start > d.max {
+		return 0, ErrElementTooLarge
+	}
+	if b == '\n' {
+		d.line++
+	}
+	return b, nil
+}
+
+// unreadByte unreads the byte returned by the last call to readByte.
+func (d *Decoder) unreadByte() {
+	d.pos--
+	if d.buf[d.pos] == '\n' {
+		d.line--
+	}
+}
+
+// checkChars returns a syntax error if b is not valid UTF-8 or contains
+// characters outside of the XML Char production.
+func (d *Decoder) checkChars(b []byte) error {
+	for i := 0; i < len(b); {
+		if b[i] < utf8.RuneSelf {
+			if b[i] < 0x20 && !isSpace(b[i]) {
+				return d.syntaxError(fmt.Sprintf("illegal character code %U", rune(b[i])))
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			return d.syntaxError("invalid UTF-8")
+		}
+		if !isChar(r) {
+			return d.syntaxError(fmt.Sprintf("illegal character code %U", r))
+		}
+		i += size
+	}
+	return nil
+}
+
+func (d *Decoder) syntaxError(msg string) error {
+	return &xml.SyntaxError{Msg: msg, Line: d.line}
+}
+
+func (n qname) String() string {
+	if n.prefix == "" {
+		return n.local
+	}
+	return n.prefix + ":" + n.local
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
+
+// isChar reports whether r is in the XML Char production.
+func isChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// isName reports whether b is a qualified name: an NCName, optionally
+// preceded by a prefix NCName and a colon.
+func isName(b []byte) bool {
+	start, colon := true, false
+	for i := 0; i < len(b); {
+		r, size := rune(b[i]), 1
+		if r >= utf8.RuneSelf {
+			r, size = utf8.DecodeRune(b[i:])
+			if r == utf8.RuneError && size == 1 {
+				return false
+			}
+		}
+		i += size
+		switch {
+		case r == ':':
+			if start || colon {
+				return false
+			}
+			start, colon = true, true
+			continue
+		case start:
+			if !isNameStartChar(r) {
+				return false
+			}
+		case !isNameChar(r):
+			return false
+		}
+		start = false
+	}
+	return !start
+}
+
+// isNameStartChar reports whether r is in the XML NameStartChar production,
+// except for the colon.
+func isNameStartChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' ||
+		r >= 0xC0 && r <= 0xD6 ||
+		r >= 0xD8 && r <= 0xF6 ||
+		r >= 0xF8 && r <= 0x2FF ||
+		r >= 0x370 && r <= 0x37D ||
+		r >= 0x37F && r <= 0x1FFF ||
+		r >= 0x200C && r <= 0x200D ||
+		r >= 0x2070 && r <= 0x218F ||
+		r >= 0x2C00 && r <= 0x2FEF ||
+		r >= 0x3001 && r <= 0xD7FF ||
+		r >= 0xF900 && r <= 0xFDCF ||
+		r >= 0xFDF0 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0xEFFFF
+}
+
+// isNameChar reports whether r is in the XML NameChar production, except for
+// the colon.
+func isNameChar(r rune) bool {
+	return isNameStartChar(r) || r >= '0' && r <= '9' || r == '-' || r == '.' ||
+		r == 0xB7 ||
+		r >= 0x300 && r <= 0x36F ||
+		r >= 0x203F && r <= 0x2040
+}
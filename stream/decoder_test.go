@@ -0,0 +1,245 @@
+package stream
+
+import (
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/namespace"
+)
+
+const testStream = `<?xml version='1.0'?>` +
+	`<stream:stream to='example.com' xml:lang='en' version='1.0' ` +
+	`xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'>` +
+	"\n<stream:features><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'/></stream:features>\n" +
+	`<message to="romeo@example.net" id='a&amp;b'>` +
+	`<body>&lt;3 &#x263A;&#65;<![CDATA[<i>]]></body>` +
+	`<x:foo xmlns:x='urn:example' x:bar='baz'/>` +
+	`</message>` +
+	` </stream:stream>`
+
+func TestDecoderNext(t *testing.T) {
+	t.Parallel()
+
+	// Should decode the elements of a stream even if it arrives one byte at a
+	// time.
+	dec := NewDecoder(iotest.OneByteReader(strings.NewReader(testStream)))
+
+	el, err := dec.Next()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	h, err := NewHeader(el)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	want := Header{To: "example.com", Lang: "en", Version: "1.0", Namespace: "jabber:client"}
+	if h != want {
+		t.Error("Should return the stream header without reading its children.")
+		t.Errorf("\nWant:%+v\nGot :%+v", want, h)
+	}
+
+	el, err = dec.Next()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if el.Space != namespace.Stream || el.Tag != "features" ||
+		!el.SelectElement("bind").MatchNamespace(namespace.Bind) {
+		t.Error("Should resolve the namespaces of the stream header.")
+		t.Errorf("Got :%+v", el)
+	}
+
+	el, err = dec.Next()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	wantEl := element.Element{
+		Space: "jabber:client", Tag: "message",
+		Attr: []element.Attr{
+			{Key: "to", Value: "romeo@example.net"},
+			{Key: "id", Value: "a&b"},
+		},
+		Child: []element.Token{
+			element.Element{
+				Space: "jabber:client", Tag: "body",
+				Child: []element.Token{element.NewCharData("<3 ☺A<i>")},
+			},
+			element.Element{
+				Space: "urn:example", Tag: "foo",
+				Attr: []element.Attr{
+					{Space: "xmlns", Key: "x", Value: "urn:example"},
+					{Space: "urn:example", Key: "bar", Value: "baz"},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(wantEl, el) {
+		t.Error("Should decode elements, entities and CDATA sections.")
+		t.Errorf("\nWant:%+v\nGot :%+v", wantEl, el)
+	}
+
+	// Should return ErrStreamClosed at the end of the stream header.
+	_, err = dec.Next()
+	if err != ErrStreamClosed {
+		t.Errorf("\nWant:%s\nGot :%s", ErrStreamClosed, err)
+	}
+	if got := dec.InputOffset(); got != int64(len(testStream)) {
+		t.Error("Should track the offset in the stream.")
+		t.Errorf("\nWant:%d\nGot :%d", len(testStream), got)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name, in string
+	}{
+		{"comment", "<a><!-- comment --></a>"},
+		{"DTD", "<!DOCTYPE a><a/>"},
+		{"processing instruction", "<a><?php ?></a>"},
+		{"entity reference", "<a>&nbsp;</a>"},
+		{"invalid character reference", "<a>&#0;</a>"},
+		{"mismatched end element", "<a><b></a>"},
+		{"unexpected end element", "</a>"},
+		{"redefined attribute", "<a b='1' b='2'/>"},
+		{"unquoted attribute", "<a b=1/>"},
+		{"< in attribute", "<a b='<'/>"},
+		{"text outside of an element", "foo<a/>"},
+		{"NUL character", "<a>\x00</a>"},
+		{"invalid UTF-8", "<a>\xff</a>"},
+		{"surrogate", "<a>\xed\xa0\x80</a>"},
+		{"illegal character in attribute", "<a b='\x01'/>"},
+		{"invalid UTF-8 in CDATA", "<a><![CDATA[\xff]]></a>"},
+		{"invalid name character", "<a&b/>"},
+		{"invalid name start character", "<1a/>"},
+		{"two colons in name", "<a:b:c/>"},
+		{"invalid attribute name", "<a b&c='1'/>"},
+		{"top level CDATA", "<![CDATA[foo]]><a/>"},
+		{"declaration before an element", "<?xml version='1.0'?><a/>"},
+		{"repeated declaration", "<?xml version='1.0'?><?xml version='1.0'?>"},
+		{"]]> in text", "<a>foo]]>bar</a>"},
+		{"]]]> in text", "<a><b/>]]]></a>"},
+	}
+	for _, tc := range tests {
+		// Should reject constructs XMPP does not allow.
+		_, err := NewDecoder(strings.NewReader(tc.in)).Next()
+		if !isSyntaxError(err) {
+			t.Errorf("%s: Wanted *xml.SyntaxError, Got:(%T)%v", tc.name, err, err)
+		}
+	}
+
+	// Should accept ]]> when it is not written literally.
+	for in, want := range map[string]string{
+		"<a>]]&gt;</a>":          "]]>",
+		"<a>]&#93;></a>":         "]]>",
+		"<a>]>]]</a>":            "]>]]",
+		"<a><![CDATA[]]]]>></a>": "]]>",
+	} {
+		el, err := NewDecoder(strings.NewReader(in)).Next()
+		if err != nil || el.Text() != want {
+			t.Errorf("\nWant:%s\nGot :%s %v", want, el.Text(), err)
+		}
+	}
+
+	// Should only accept stream headers at the top level.
+	header := "<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'>"
+	dec := NewDecoder(strings.NewReader(header + "<a/>" + header + "<b/>"))
+	for _, want := range []string{"stream", "a", "stream", "b"} {
+		el, err := dec.Next()
+		if err != nil || el.Tag != want {
+			t.Errorf("\nWant:%s\nGot :%s %v", want, el.Tag, err)
+		}
+	}
+	for _, in := range []string{
+		"<a><stream:stream xmlns:stream='http://etherx.jabber.org/streams'></a>",
+		"<a><b><stream:stream xmlns:stream='http://etherx.jabber.org/streams'></b></a>",
+	} {
+		dec = NewDecoder(strings.NewReader(header + in))
+		if _, err := dec.Next(); err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if _, err := dec.Next(); !isSyntaxError(err) {
+			t.Errorf("%s: Wanted *xml.SyntaxError, Got:(%T)%v", in, err, err)
+		}
+	}
+
+	// Should only accept an XML declaration before a stream header.
+	dec = NewDecoder(strings.NewReader("<?xml version='1.0'?>" + header + "<a/><?xml version='1.0'?>" + header +
+		"<?xml version='1.0'?><a/>"))
+	for i := 0; i < 3; i++ {
+		if _, err := dec.Next(); err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	}
+	if _, err := dec.Next(); !isSyntaxError(err) {
+		t.Errorf("Wanted *xml.SyntaxError, Got:(%T)%v", err, err)
+	}
+
+	// Should return an error once an element exceeds the maximum size.
+	dec = NewDecoder(strings.NewReader("<a/><b>" + strings.Repeat("x", 100) + "</b>")).
+		SetMaxElementSize(64)
+	if _, err := dec.Next(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if _, err := dec.Next(); err != ErrElementTooLarge {
+		t.Errorf("\nWant:%s\nGot :%v", ErrElementTooLarge, err)
+	}
+	// Should keep returning the error.
+	if _, err := dec.Next(); err != ErrElementTooLarge {
+		t.Errorf("\nWant:%s\nGot :%v", ErrElementTooLarge, err)
+	}
+
+	// Should return the error of the reader.
+	_, err := NewDecoder(strings.NewReader("<a>")).Next()
+	if err != io.EOF {
+		t.Errorf("\nWant:%s\nGot :%v", io.EOF, err)
+	}
+}
+
+func isSyntaxError(err error) bool {
+	_, ok := err.(*xml.SyntaxError)
+	return ok
+}
+
+func benchmarkStream(n int) string {
+	header := `<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'>`
+	message := `<message from='juliet@example.com/balcony' to='romeo@example.net' type='chat' id='ktx72v49'>` +
+		`<body>Art thou not Romeo, and a Montague?</body>` +
+		`<active xmlns='http://jabber.org/protocol/chatstates'/></message>`
+	return header + strings.Repeat(message, n)
+}
+
+func BenchmarkDecoderNext(b *testing.B) {
+	b.ReportAllocs()
+	r := strings.NewReader(benchmarkStream(b.N))
+	dec := NewDecoder(r)
+	if _, err := dec.Next(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := dec.Next(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkXMLDecoder(b *testing.B) {
+	b.ReportAllocs()
+	dec := xml.NewDecoder(strings.NewReader(benchmarkStream(b.N)))
+	if _, err := dec.Token(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var el element.Element
+		if err := dec.Decode(&el); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
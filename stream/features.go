@@ -106,8 +106,11 @@ func (fm FeaturesMux) Handler(els []element.Element) (fh FeatureHandler, elem el
 
 // HandleElement handles the stream:features element. It finds the
 // FeatureHandler to call for the given feature children elements invokes it.
+// The features are stored in the properties so handlers can inspect the other
+// features offered.
 func (fm FeaturesMux) HandleElement(el element.Element, p Properties) ([]element.Element, Properties) {
 	children := el.ChildElements()
+	p.Features = children
 	h, elem := fm.Handler(children)
 	return h.HandleFeature(elem, p)
 }
@@ -40,7 +40,7 @@ func NewHeader(el element.Element) (strm Header, err error) {
 		case "id":
 			strm.ID = attr.Value
 		case "lang":
-			if attr.Space == "xml" {
+			if attr.Space == "xml" || attr.Space == xmlURL {
 				strm.Lang = attr.Value
 			}
 		case "version":
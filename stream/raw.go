@@ -0,0 +1,159 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/skriptble/nine/element"
+)
+
+// Raw is a top level element kept as the bytes it was read as. The header
+// attributes of the element are parsed from the bytes when they are asked
+// for, and the element is only decoded by a call to Element. Routers can use
+// it to forward stanzas without decoding and reserializing them.
+type Raw struct {
+	// Space and Tag are the namespace and tag of the element.
+	Space, Tag string
+
+	b []byte
+	// scope holds the namespace declarations of the stream the element was
+	// read from, which the element's own bytes may rely on.
+	scope []binding
+}
+
+// Bytes returns the bytes of the element. The slice must not be modified.
+func (r Raw) Bytes() []byte {
+	return r.b
+}
+
+// WriteTo implements io.WriterTo. The bytes of the element are written
+// unchanged.
+func (r Raw) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(r.b)
+	return int64(n), err
+}
+
+// Element decodes the bytes into an element.Element.
+func (r Raw) Element() (element.Element, error) {
+	return newBytesDecoder(r.b, r.scope).Next()
+}
+
+// To returns the to attribute of the element.
+func (r Raw) To() string {
+	return r.Attr("to")
+}
+
+// From returns the from attribute of the element.
+func (r Raw) From() string {
+	return r.Attr("from")
+}
+
+// Type returns the type attribute of the element.
+func (r Raw) Type() string {
+	return r.Attr("type")
+}
+
+// ID returns the id attribute of the element.
+func (r Raw) ID() string {
+	return r.Attr("id")
+}
+
+// Attr returns the value of the unprefixed attribute with the key. If the
+// element does not have the attribute an empty string is returned.
+func (r Raw) Attr(key string) string {
+	start, end := attrSpan(r.b, key)
+	if start < 0 {
+		return ""
+	}
+	v := r.b[start:end]
+	if bytes.IndexByte(v, '&') < 0 {
+		return string(v)
+	}
+	d := newBytesDecoder(v, nil)
+	var dst []byte
+	for {
+		b, err := d.readByte()
+		if err != nil {
+			return string(dst)
+		}
+		if b != '&' {
+			dst = append(dst, b)
+			continue
+		}
+		dst, err = d.entity(dst)
+		if err != nil {
+			return string(dst)
+		}
+	}
+}
+
+// SetFrom returns a copy of the element with the from attribute set to from.
+// The rest of the bytes are kept unchanged.
+func (r Raw) SetFrom(from string) Raw {
+	start, end := attrSpan(r.b, "from")
+	if start < 0 {
+		// Add the attribute after the name.
+		start = nameEnd(r.b)
+		b := make([]byte, 0, len(r.b)+len(from)+8)
+		b = append(b, r.b[:start]...)
+		b = append(b, " from='"...)
+		b = element.AppendEscaped(b, from)
+		b = append(b, '\'')
+		r.b = append(b, r.b[start:]...)
+		return r
+	}
+	b := make([]byte, 0, len(r.b)-(end-start)+len(from))
+	b = append(b, r.b[:start]...)
+	b = element.AppendEscaped(b, from)
+	r.b = append(b, r.b[end:]...)
+	return r
+}
+
+// nameEnd returns the index of the end of the name in a start tag.
+func nameEnd(b []byte) int {
+	i := 1
+	for i < len(b) && !isSpace(b[i]) && b[i] != '>' && b[i] != '/' {
+		i++
+	}
+	return i
+}
+
+// attrSpan returns the start and end of the value of the unprefixed
+// attribute with the key in the start tag at the beginning of b. If there is
+// no such attribute -1 is returned for both.
+func attrSpan(b []byte, key string) (start, end int) {
+	i := nameEnd(b)
+	for i < len(b) {
+		for i < len(b) && isSpace(b[i]) {
+			i++
+		}
+		if i >= len(b) || b[i] == '>' || b[i] == '/' {
+			break
+		}
+		n := i
+		for i < len(b) && b[i] != '=' && !isSpace(b[i]) {
+			i++
+		}
+		name := b[n:i]
+		for i < len(b) && b[i] != '\'' && b[i] != '"' {
+			i++
+		}
+		if i >= len(b) {
+			break
+		}
+		quote := b[i]
+		i++
+		start = i
+		for i < len(b) && b[i] != quote {
+			i++
+		}
+		if i >= len(b) {
+			break
+		}
+		if string(name) == key {
+			return start, i
+		}
+		i++
+	}
+	return -1, -1
+}
@@ -0,0 +1,159 @@
+package stream
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skriptble/nine/element"
+)
+
+const rawStream = `<stream:stream xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'>` +
+	`<message to='romeo@example.net' type="chat" id='a&amp;b'><body>Wherefore art thou?</body></message>` +
+	`<presence/>`
+
+func TestDecoderNextRaw(t *testing.T) {
+	t.Parallel()
+
+	dec := NewDecoder(strings.NewReader(rawStream))
+	header, err := dec.NextRaw()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	el, err := header.Element()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err = NewHeader(el); err != nil {
+		t.Errorf("Should decode the stream header from a Raw: %s", err)
+	}
+
+	raw, err := dec.NextRaw()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Should keep the bytes of the element.
+	want := `<message to='romeo@example.net' type="chat" id='a&amp;b'><body>Wherefore art thou?</body></message>`
+	if got := string(raw.Bytes()); got != want {
+		t.Error("Should keep the bytes of the element.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+
+	// Should parse the header attributes.
+	got := []string{raw.Space, raw.Tag, raw.To(), raw.From(), raw.Type(), raw.ID()}
+	wantAttrs := []string{"jabber:client", "message", "romeo@example.net", "", "chat", "a&b"}
+	if !reflect.DeepEqual(wantAttrs, got) {
+		t.Error("Should parse the header attributes.")
+		t.Errorf("\nWant:%q\nGot :%q", wantAttrs, got)
+	}
+
+	// Should decode the element with the namespaces of the stream.
+	el, err = raw.Element()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if el.Space != "jabber:client" || el.SelectElement("body").Text() != "Wherefore art thou?" {
+		t.Error("Should decode the element with the namespaces of the stream.")
+		t.Errorf("Got :%s", el)
+	}
+
+	// Should be able to mix Next and NextRaw.
+	el, err = dec.Next()
+	if err != nil || el.Tag != "presence" || el.Space != "jabber:client" {
+		t.Errorf("Should be able to mix Next and NextRaw, got %s, %v", el, err)
+	}
+}
+
+func TestDecoderNextRawPrefixes(t *testing.T) {
+	t.Parallel()
+
+	dec := NewDecoder(strings.NewReader(`<stream:stream xmlns='jabber:server' ` +
+		`xmlns:stream='http://etherx.jabber.org/streams' xmlns:db='jabber:server:dialback' xmlns:x='urn:example'>` +
+		`<db:result from='example.com' to='example.net'>b4835385f37fe2895af6c196b59097b16862406db80559900d96bf6fa7d23df3</db:result>` +
+		`<message x:a='1'><x:b xmlns:x='urn:other'/></message>`))
+	if _, err := dec.NextRaw(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Should declare the prefixes inherited from the stream header.
+	wants := []string{
+		`<db:result xmlns:db='jabber:server:dialback' from='example.com' to='example.net'>` +
+			`b4835385f37fe2895af6c196b59097b16862406db80559900d96bf6fa7d23df3</db:result>`,
+		`<message xmlns:x='urn:example' x:a='1'><x:b xmlns:x='urn:other'/></message>`,
+	}
+	var els []element.Element
+	for _, want := range wants {
+		raw, err := dec.NextRaw()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if got := string(raw.Bytes()); got != want {
+			t.Error("Should declare the prefixes inherited from the stream header.")
+			t.Errorf("\nWant:%s\nGot :%s", want, got)
+		}
+		el, err := NewDecoder(strings.NewReader(string(raw.Bytes()))).Next()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		els = append(els, el)
+	}
+
+	// Should resolve the prefixes without the stream header.
+	if els[0].Space != "jabber:server:dialback" || els[1].Attr[1].Space != "urn:example" ||
+		els[1].SelectElement("b").Space != "urn:other" {
+		t.Error("Should resolve the prefixes without the stream header.")
+		t.Errorf("Got :%s", els)
+	}
+}
+
+func TestRawSetFrom(t *testing.T) {
+	t.Parallel()
+
+	dec := NewDecoder(strings.NewReader(rawStream))
+	dec.NextRaw()
+	raw, err := dec.NextRaw()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Should add the from attribute after the name.
+	stamped := raw.SetFrom("juliet@example.com/balcony")
+	want := `<message from='juliet@example.com/balcony' to='romeo@example.net' type="chat" id='a&amp;b'><body>Wherefore art thou?</body></message>`
+	if got := string(stamped.Bytes()); got != want {
+		t.Error("Should add the from attribute after the name.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+
+	// Should replace an existing from attribute and escape it.
+	stamped = stamped.SetFrom("o'brien@example.com")
+	want = `<message from='o&apos;brien@example.com' to='romeo@example.net' type="chat" id='a&amp;b'><body>Wherefore art thou?</body></message>`
+	if got := string(stamped.Bytes()); got != want {
+		t.Error("Should replace an existing from attribute.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+	if got := stamped.From(); got != "o'brien@example.com" {
+		t.Errorf("\nWant:%s\nGot :%s", "o'brien@example.com", got)
+	}
+
+	// Should not modify the original.
+	if raw.From() != "" {
+		t.Error("Should not modify the original.")
+	}
+}
+
+func BenchmarkDecoderNextRaw(b *testing.B) {
+	b.ReportAllocs()
+	dec := NewDecoder(strings.NewReader(benchmarkStream(b.N)))
+	if _, err := dec.NextRaw(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		raw, err := dec.NextRaw()
+		if err != nil {
+			b.Fatal(err)
+		}
+		raw.SetFrom("romeo@example.net/orchard")
+	}
+}
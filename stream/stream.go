@@ -1,6 +1,7 @@
 package stream
 
 import (
+	"crypto/tls"
 	"encoding/xml"
 	"errors"
 	"io"
@@ -11,6 +12,8 @@ import (
 
 	"github.com/skriptble/nine/element"
 	"github.com/skriptble/nine/element/stanza"
+	"github.com/skriptble/nine/jid"
+	"github.com/skriptble/nine/namespace"
 )
 
 // ErrStreamClosed is the error returned when the stream has been closed.
@@ -85,6 +88,39 @@ type Properties struct {
 	// The XMPP domain of this server.
 	Domain   string
 	Features []element.Element
+
+	// The full JID bound to the stream. It is empty until a resource has
+	// been bound.
+	JID jid.JID
+
+	// The network address of the remote entity, if known.
+	RemoteAddr string
+	// The state of the TLS connection if the stream has been secured.
+	TLS *tls.ConnectionState
+
+	// SASL holds the state of SASL negotiation for the stream. It is set and
+	// cleared by the sasl package and is nil otherwise.
+	SASL interface{}
+
+	// Conn is the connection of the stream. It is set when the stream is run
+	// and allows other streams to write to or close this stream, for example
+	// when a session is replaced.
+	Conn Conn
+}
+
+// Conn is the interface implemented by the connection of a running stream.
+// Implementations must be safe to call from outside of the stream's own
+// goroutine.
+type Conn interface {
+	io.Closer
+	WriteElement(el element.Element) error
+}
+
+// CloseHandler is the interface implemented by types that need to be notified
+// when a stream ends, for example to release resources held by the stream.
+// The properties are the last properties of the stream.
+type CloseHandler interface {
+	HandleClose(Properties)
 }
 
 // NewProperties initializes and returns a Properties object.
@@ -111,6 +147,16 @@ type Transport interface {
 	Start(Properties) (Properties, error)
 }
 
+// RawTransport is implemented by transports which can return top level
+// elements without decoding them. Routers can use it to forward stanzas
+// unchanged.
+type RawTransport interface {
+	Transport
+
+	NextRaw() (Raw, error)
+	WriteRaw(Raw) error
+}
+
 // Stream represents an RFC6120 stream. It is a semi-finate state machine:
 // the Properties object has a Status field which determines the stages of
 // stream negotiation.
@@ -123,6 +169,7 @@ type Stream struct {
 	h   ElementHandler
 	t   Transport
 	fhs []FeatureGenerator
+	chs []CloseHandler
 
 	mode Mode
 }
@@ -150,6 +197,13 @@ func (s Stream) AddFeatureHandlers(hdlrs ...FeatureGenerator) Stream {
 	return s
 }
 
+// AddCloseHandlers appends the given handlers to the end of the close handlers
+// for the stream. They are called in order when Run returns.
+func (s Stream) AddCloseHandlers(hdlrs ...CloseHandler) Stream {
+	s.chs = append(s.chs, hdlrs...)
+	return s
+}
+
 func syntaxError(err error) bool {
 	_, ok := err.(*xml.SyntaxError)
 	return ok
@@ -164,11 +218,17 @@ func networkError(err error) bool {
 // stream and then retrieving elements. The elements retrieved are passed to
 // the stream's element handler.
 //
-// Run will return when an error has occured or the stream has closed. This
+// Run will return when an error has occured or the stream has closed. The
+// close handlers of the stream are called before it returns. This
 // should only be called once, although calling it more than once won't cause
 // a panic. The functionality of the stream if Run is called more than once is
 // undefined.
 func (s Stream) Run() {
+	defer func() {
+		for _, ch := range s.chs {
+			ch.HandleClose(s.Properties)
+		}
+	}()
 	defer func() {
 		if r := recover(); r != nil {
 			// Something panicked so our state is probably bad, cleanly shut
@@ -184,6 +244,7 @@ func (s Stream) Run() {
 	// Start the stream
 	Trace.Println("Running stream.")
 	s.Properties.Status = s.Properties.Status | Restart
+	s.Properties.Conn = s.t
 
 	// Start recieving elements
 	for {
@@ -228,14 +289,29 @@ func (s Stream) Run() {
 			case networkError(err):
 				Debug.Printf("Network error. Stopping. err: %s", err)
 				return
+			case err == ErrElementTooLarge:
+				Debug.Println("Element too large. Closing stream.")
+				s.t.WriteElement(element.StreamError.PolicyViolation)
+				s.t.Close()
+				return
 			case err == ErrStreamClosed:
 				Trace.Println("Stream close recieved. Closing stream.")
 				s.t.Close()
 				return
-				// TODO: Add a default case. This should probably close the stream.
+			default:
+				Debug.Printf("Error while reading element. Closing stream. err: %s", err)
+				s.t.Close()
+				return
 			}
-		} else {
+		}
 
+		// In initiating mode the header of the receiving entity is read as an
+		// element. Record it instead of passing it to the handler.
+		if s.mode == Initiating && el.Space == namespace.Stream && el.Tag == "stream" {
+			if h, err := NewHeader(el); err == nil {
+				s.Properties.Header.ID = h.ID
+			}
+			continue
 		}
 
 		var elems []element.Element
@@ -245,6 +321,11 @@ func (s Stream) Run() {
 			Trace.Printf("Writing element: %s", elem)
 			s.t.WriteElement(elem)
 		}
+		if s.Properties.Status&Closed != 0 {
+			Trace.Println("Stream marked as closed. Closing stream.")
+			s.t.Close()
+			return
+		}
 	}
 }
 
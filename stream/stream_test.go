@@ -0,0 +1,69 @@
+package stream
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skriptble/nine/element"
+	"github.com/skriptble/nine/element/stanza"
+)
+
+// decoderTransport is a Transport which reads elements with a Decoder and
+// records the elements written to it.
+type decoderTransport struct {
+	dec     *Decoder
+	written []element.Element
+	closed  bool
+}
+
+func (dt *decoderTransport) WriteElement(el element.Element) error {
+	dt.written = append(dt.written, el)
+	return nil
+}
+
+func (dt *decoderTransport) WriteStanza(st stanza.Stanza) error {
+	return dt.WriteElement(st.TransformElement())
+}
+
+func (dt *decoderTransport) Next() (element.Element, error) { return dt.dec.Next() }
+
+func (dt *decoderTransport) Start(props Properties) (Properties, error) { return props, nil }
+
+func (dt *decoderTransport) Close() error {
+	dt.closed = true
+	return nil
+}
+
+type countingHandler int
+
+func (c *countingHandler) HandleElement(el element.Element, props Properties) ([]element.Element, Properties) {
+	*c++
+	return nil, props
+}
+
+func TestRunElementTooLarge(t *testing.T) {
+	t.Parallel()
+
+	dt := &decoderTransport{
+		dec: NewDecoder(strings.NewReader("<a/><b>" + strings.Repeat("x", 100) + "</b><c/>")).SetMaxElementSize(64),
+	}
+	var handled countingHandler
+	done := make(chan struct{})
+	go func() {
+		New(dt, &handled, Receiving).Run()
+		close(done)
+	}()
+
+	// Should end the stream with a policy-violation stream error.
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Should end the stream when an element is too large.")
+	}
+	if handled != 1 || !dt.closed || len(dt.written) != 1 ||
+		dt.written[0].SelectElement("policy-violation").Tag == "" {
+		t.Error("Should end the stream with a policy-violation stream error.")
+		t.Errorf("\nGot :%d handled, closed %t, %s", handled, dt.closed, dt.written)
+	}
+}
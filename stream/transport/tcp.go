@@ -3,26 +3,37 @@ package transport
 import (
 	"crypto/rand"
 	"crypto/tls"
-	"encoding/xml"
 	"fmt"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/skriptble/nine/element"
 	"github.com/skriptble/nine/element/stanza"
-	"github.com/skriptble/nine/namespace"
 	"github.com/skriptble/nine/stream"
 )
 
 // TCP is a stream transport that uses a TCP socket as described in RFC6120.
+//
+// WriteElement, WriteStanza, WriteRaw, Write and Close can be called from
+// other goroutines than the one reading from the transport, for example when
+// a session is replaced by another one.
 type TCP struct {
 	net.Conn
-	*xml.Decoder
+	dec *stream.Decoder
+
+	// mu serializes writes to Conn. Replacing Conn with the tls connection
+	// after a starttls upgrade holds both mu and connMu, so Close only needs
+	// connMu and is not blocked by a pending write.
+	mu     sync.Mutex
+	connMu sync.Mutex
 
-	mode        stream.Mode
-	tlsRequired bool
-	conf        *tls.Config
-	secure      bool
+	maxElementSize  int64
+	mode            stream.Mode
+	tlsRequired     bool
+	conf            *tls.Config
+	secure          bool
+	stripWhitespace bool
 }
 
 // NewTCP creates and returns a TCP stream.Transport
@@ -32,21 +43,58 @@ type TCP struct {
 //
 // If conf is nil, the starttls feature will not be presented.
 func NewTCP(c net.Conn, mode stream.Mode, conf *tls.Config, tlsRequired bool) stream.Transport {
-	dec := xml.NewDecoder(c)
-	return &TCP{Conn: c, Decoder: dec, mode: mode, conf: conf, tlsRequired: tlsRequired}
+	dec := stream.NewDecoder(c)
+	return &TCP{Conn: c, dec: dec, mode: mode, conf: conf, tlsRequired: tlsRequired}
+}
+
+// SetMaxElementSize sets the maximum size in bytes of the elements read by
+// Next. Larger elements make Next return stream.ErrElementTooLarge. A size of
+// zero or less disables the limit.
+func (t *TCP) SetMaxElementSize(n int64) *TCP {
+	t.maxElementSize = n
+	t.dec.SetMaxElementSize(n)
+	return t
 }
 
-// WriteElement converts the element to bytes and writes to the underlying
-// tcp connection. This method should generally be used for basic elements such
-// as those used during SASL negotiation. WriteStanzas should be used when
-// sending stanzas.
+// InputOffset returns the number of bytes read from the connection since it
+// was last upgraded.
+func (t *TCP) InputOffset() int64 {
+	return t.dec.InputOffset()
+}
+
+// StripWhitespace sets whether whitespace-only character data between child
+// elements is removed from the elements returned by Next.
+func (t *TCP) StripWhitespace(strip bool) *TCP {
+	t.stripWhitespace = strip
+	return t
+}
+
+// WriteElement serializes the element into a pooled buffer and writes it to
+// the underlying tcp connection with a single write. This method should
+// generally be used for basic elements such as those used during SASL
+// negotiation. WriteStanzas should be used when sending stanzas.
 func (t *TCP) WriteElement(el element.Element) error {
-	var b []byte
-	b = el.WriteBytes()
-	_, err := t.Write(b)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := el.WriteTo(t.Conn)
 	return err
 }
 
+// Write writes the bytes to the underlying connection.
+func (t *TCP) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Conn.Write(b)
+}
+
+// Close closes the underlying connection. It unblocks pending writes.
+func (t *TCP) Close() error {
+	t.connMu.Lock()
+	conn := t.Conn
+	t.connMu.Unlock()
+	return conn.Close()
+}
+
 // WriteStanzas converts the stanza to bytes and writes them to the underlying
 // tcp connection. This method should be used whenever stanzas are being used
 // instead of transforming the stanza to an element and using WriteElement.
@@ -81,21 +129,40 @@ func (t *TCP) Next() (el element.Element, err error) {
 			}
 		}
 	}()
-	var token xml.Token
-	for {
-		token, err = t.Token()
-		if err != nil {
-			return
-		}
+	el, err = t.dec.Next()
+	if err == nil && t.stripWhitespace {
+		el = el.StripWhitespace()
+	}
+	return
+}
 
-		switch elem := token.(type) {
-		case xml.StartElement:
-			return t.createElement(elem)
-		case xml.EndElement:
-			err = stream.ErrStreamClosed
-			return
-		}
+// NextRaw returns the next element from the stream without decoding it. It
+// is meant for stanzas that are forwarded to another stream, which only need
+// their header attributes. Like Next, it handles a starttls upgrade itself.
+func (t *TCP) NextRaw() (raw stream.Raw, err error) {
+	raw, err = t.dec.NextRaw()
+	if err != nil || t.secure || (raw.Tag != "starttls" && raw.Tag != "features") {
+		return
+	}
+	var el element.Element
+	el, err = raw.Element()
+	if err != nil {
+		return stream.Raw{}, err
+	}
+	if el.Tag == "starttls" || el.SelectElement("starttls").Tag != "" {
+		_, err = t.startTLS()
+		return stream.Raw{}, err
 	}
+	return
+}
+
+// WriteRaw writes the bytes of the element to the underlying tcp connection
+// unchanged.
+func (t *TCP) WriteRaw(raw stream.Raw) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := raw.WriteTo(t.Conn)
+	return err
 }
 
 func (t *TCP) startTLS() (el element.Element, err error) {
@@ -123,8 +190,12 @@ func (t *TCP) startTLS() (el element.Element, err error) {
 		return
 	}
 	conn := net.Conn(tlsConn)
+	t.mu.Lock()
+	t.connMu.Lock()
 	t.Conn = conn
-	t.Decoder = xml.NewDecoder(conn)
+	t.connMu.Unlock()
+	t.mu.Unlock()
+	t.dec = stream.NewDecoder(conn).SetMaxElementSize(t.maxElementSize)
 	el = element.Element{}
 	err = stream.ErrRequireRestart
 	t.secure = true
@@ -139,6 +210,11 @@ func (t *TCP) startTLS() (el element.Element, err error) {
 // features. This transport will add the starttls feature under certain
 // conditions.
 func (t *TCP) Start(props stream.Properties) (stream.Properties, error) {
+	if tlsConn, ok := t.Conn.(*tls.Conn); ok && t.secure {
+		state := tlsConn.ConnectionState()
+		props.TLS = &state
+		props.Status = props.Status | stream.Secure
+	}
 	if t.mode == stream.Initiating {
 		if props.Header == (stream.Header{}) {
 			return props, stream.ErrHeaderNotSet
@@ -183,6 +259,9 @@ func (t *TCP) Start(props stream.Properties) (stream.Properties, error) {
 	}
 
 	props.Header = h
+	if props.RemoteAddr == "" && t.RemoteAddr() != nil {
+		props.RemoteAddr = t.RemoteAddr().String()
+	}
 
 	b := props.Header.WriteBytes()
 	_, err = t.Write(b)
@@ -207,62 +286,6 @@ func (t *TCP) Start(props stream.Properties) (stream.Properties, error) {
 	return props, err
 }
 
-// createElement creates an element from the given xml.StartElement, populates
-// its attributes and children and returns it.
-func (t *TCP) createElement(start xml.StartElement) (el element.Element, err error) {
-	var children []element.Token
-
-	el = element.Element{
-		Space: start.Name.Space,
-		Tag:   start.Name.Local,
-	}
-	for _, attr := range start.Attr {
-		el.Attr = append(
-			el.Attr,
-			element.Attr{
-				Space: attr.Name.Space,
-				Key:   attr.Name.Local,
-				Value: attr.Value,
-			},
-		)
-	}
-	// If this is a stream start return only this element.
-	if el.Tag == "stream" && el.Space == namespace.Stream {
-		return
-	}
-
-	children, err = t.childElements()
-	el.Child = children
-	return
-}
-
-// childElements retrieves child tokens. This method should be called after
-// createElement.
-func (t *TCP) childElements() (children []element.Token, err error) {
-	var token xml.Token
-	var el element.Element
-	for {
-		token, err = t.Token()
-		if err != nil {
-			return
-		}
-
-		switch elem := token.(type) {
-		case xml.StartElement:
-			el, err = t.createElement(elem)
-			if err != nil {
-				return
-			}
-			children = append(children, el)
-		case xml.EndElement:
-			return
-		case xml.CharData:
-			data := string(elem)
-			children = append(children, element.CharData{Data: data})
-		}
-	}
-}
-
 // genStreamID creates a new stream ID based on a uuid.
 func genStreamID() string {
 	id := make([]byte, 16)
@@ -10,6 +10,7 @@ import (
 	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/skriptble/nine/element"
 	"github.com/skriptble/nine/element/stanza"
@@ -311,6 +312,80 @@ func TestStartTLS(t *testing.T) {
 	}
 }
 
+func TestStartTLSWriteAndClose(t *testing.T) {
+	t.Parallel()
+
+	cert, err := tls.X509KeyPair(certificatePEM, keyPEM)
+	if err != nil {
+		t.Errorf("Unexpected error while loading key pairs: %s", err)
+	}
+
+	// The test certificates have expired, this test is not about verifying
+	// them.
+	serverPipe, clientPipe := net.Pipe()
+	tcpTsp := NewTCP(serverPipe, stream.Receiving, &tls.Config{Certificates: []tls.Certificate{cert}}, true)
+	client := tls.Client(clientPipe, &tls.Config{InsecureSkipVerify: true})
+
+	go func() {
+		clientPipe.Write(element.StartTLS.WriteBytes())
+		proceed := make([]byte, len(element.TLSProceed.WriteBytes()))
+		clientPipe.Read(proceed)
+		if err := client.Handshake(); err != nil {
+			t.Errorf("Error while performing handshake: %s", err)
+		}
+	}()
+	if _, err = tcpTsp.Next(); err != stream.ErrRequireRestart {
+		t.Fatalf("Expected require restart error, received %s", err)
+	}
+
+	// Should write to and close the upgraded connection from other goroutines.
+	el := element.New("testing").AddAttr("xmlns", "foo:bar")
+	want := el.WriteBytes()
+	written := make(chan error, 1)
+	go func() {
+		err := tcpTsp.WriteElement(el)
+		if err == nil {
+			err = tcpTsp.Close()
+		}
+		written <- err
+	}()
+	got := make([]byte, len(want))
+	if _, err = io.ReadFull(client, got); err != nil {
+		t.Errorf("Received error while reading from connection: %s", err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Error("Should write to the upgraded connection.")
+		t.Errorf("\nWant:%s\nGot :%s", want, got)
+	}
+	if _, err = client.Read(got); err != io.EOF {
+		t.Errorf("Should close the upgraded connection. Got: %v", err)
+	}
+	if err = <-written; err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestCloseDuringWrite(t *testing.T) {
+	t.Parallel()
+
+	// Should unblock a write to a peer which stopped reading.
+	_, pipe := net.Pipe()
+	tcpTsp := NewTCP(pipe, stream.Receiving, nil, false)
+	written := make(chan error, 1)
+	go func() { written <- tcpTsp.WriteElement(element.StreamError.Conflict) }()
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- tcpTsp.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Should not block Close behind a pending write.")
+	}
+	if err := <-written; err == nil {
+		t.Error("Should unblock a write to a peer which stopped reading.")
+	}
+}
+
 func TestNextError(t *testing.T) {
 	t.Parallel()
 